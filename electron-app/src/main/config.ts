@@ -10,12 +10,16 @@ export const config = {
     maxRestarts: 5,
     restartDelay: 3000,
     startupTimeout: 10000,
+    // 停止时等待进行中的交易完成，超时后强制结束 (需大于服务端 -shutdown-timeout)
+    shutdownTimeout: 80000,
+    // 主管凭证文件 (位于用户数据目录)，供大额退款审批使用
+    supervisorsFile: 'supervisors.json',
   },
 
   // WebSocket 配置
   websocket: {
     reconnectDelay: 3000,
-    url: 'ws://127.0.0.1:8989/ws',
+    url: 'ws://127.0.0.1:8989/ws?protocol_version=1',
   },
 
   // 窗口配置
@@ -109,7 +109,9 @@ function cleanup(): void {
   }
 
   if (processManager) {
-    processManager.stop();
+    // The server keeps running until its transaction finishes, even after
+    // this app has exited
+    void processManager.stop();
     processManager = null;
   }
 }
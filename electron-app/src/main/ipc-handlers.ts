@@ -16,7 +16,7 @@ export function setupIpcHandlers(
   processManager: ProcessManager,
   mainWindow: BrowserWindow
 ): { cleanup: () => void } {
-  const wsBridge = new WebSocketBridge(mainWindow);
+  const wsBridge = new WebSocketBridge(mainWindow, () => processManager.getServerToken());
 
   // ============ Go Server Control ============
 
@@ -31,8 +31,8 @@ export function setupIpcHandlers(
     }
   });
 
-  ipcMain.handle('go-server:stop', (): IpcResponse => {
-    processManager.stop();
+  ipcMain.handle('go-server:stop', async (): Promise<IpcResponse> => {
+    await processManager.stop();
     return { success: true };
   });
 
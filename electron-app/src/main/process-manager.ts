@@ -9,6 +9,7 @@
  */
 
 import { spawn, ChildProcess } from 'child_process';
+import crypto from 'crypto';
 import { app } from 'electron';
 import path from 'path';
 import fs from 'fs';
@@ -25,6 +26,8 @@ export class ProcessManager extends EventEmitter {
   private restartCount = 0;
   private isShuttingDown = false;
   private startTime: number | null = null;
+  // Token the Go Server accepts from this app, generated per launch
+  private readonly serverToken = crypto.randomBytes(32).toString('hex');
 
   constructor() {
     super();
@@ -45,6 +48,52 @@ export class ProcessManager extends EventEmitter {
     return super.on(event, listener);
   }
 
+  /**
+   * Token for connecting to the Go Server
+   */
+  getServerToken(): string {
+    return this.serverToken;
+  }
+
+  /**
+   * Supervisor credentials from supervisors.json in the user data directory,
+   * e.g. [{ "principal": "manager", "token": "..." }]. They are kept apart
+   * from this app's token so that large refunds still need a second person.
+   */
+  private readSupervisorTokens(): { principal: string; token: string; roles: string[] }[] {
+    const file = path.join(app.getPath('userData'), config.goServer.supervisorsFile);
+    if (!fs.existsSync(file)) {
+      return [];
+    }
+    try {
+      const entries = JSON.parse(fs.readFileSync(file, 'utf8')) as { principal?: string; token?: string }[];
+      return entries
+        .filter((e) => e.principal && e.token && e.principal !== 'electron')
+        .map((e) => ({ principal: e.principal!, token: e.token!, roles: ['supervisor'] }));
+    } catch (error) {
+      logger.error('Invalid supervisors file, ignoring it', { path: file }, error);
+      return [];
+    }
+  }
+
+  /**
+   * Write the Go Server config holding this app's token. The server refuses
+   * to start without credentials.
+   */
+  private writeServerConfig(): string {
+    const configPath = path.join(app.getPath('userData'), 'server-config.json');
+    const serverConfig = {
+      auth: {
+        tokens: [
+          { principal: 'electron', token: this.serverToken, roles: ['cashier'] },
+          ...this.readSupervisorTokens(),
+        ],
+      },
+    };
+    fs.writeFileSync(configPath, JSON.stringify(serverConfig, null, 2), { mode: 0o600 });
+    return configPath;
+  }
+
   /**
    * Get the path to the Go Server executable
    */
@@ -95,12 +144,15 @@ export class ProcessManager extends EventEmitter {
       throw error;
     }
 
-    // Spawn the process
-    this.goServer = spawn(serverPath, [], {
-      stdio: ['ignore', 'pipe', 'pipe'],
+    // Spawn the process. Closing its stdin asks it to shut down gracefully,
+    // which also works on Windows and when this app exits first.
+    const configPath = this.writeServerConfig();
+    const child = spawn(serverPath, ['-exit-on-stdin-close', '-config', configPath], {
+      stdio: ['pipe', 'pipe', 'pipe'],
       windowsHide: true,
       cwd: path.dirname(serverPath),
     });
+    this.goServer = child;
 
     this.startTime = Date.now();
 
@@ -131,8 +183,10 @@ export class ProcessManager extends EventEmitter {
       const uptime = this.startTime ? Date.now() - this.startTime : 0;
       logger.info('Go Server exited', { code, signal, uptime: `${uptime}ms` });
       
-      this.goServer = null;
-      this.startTime = null;
+      if (this.goServer === child) {
+        this.goServer = null;
+        this.startTime = null;
+      }
       this.emit('exit', { code, signal });
 
       // Auto-restart logic
@@ -236,37 +290,48 @@ export class ProcessManager extends EventEmitter {
   }
 
   /**
-   * Stop the Go Server
+   * Stop the Go Server. It finishes a running transaction before exiting;
+   * it is killed if it has not exited after shutdownTimeout.
    */
-  stop(): void {
+  stop(): Promise<void> {
     this.isShuttingDown = true;
 
-    if (!this.goServer) {
+    const child = this.goServer;
+    if (!child) {
       logger.debug('Go Server not running');
-      return;
+      return Promise.resolve();
     }
+    this.goServer = null;
+    this.startTime = null;
 
-    logger.info('Stopping Go Server', { pid: this.goServer.pid });
+    logger.info('Stopping Go Server', { pid: child.pid });
 
-    if (process.platform === 'win32') {
-      // Windows: use taskkill for clean shutdown
-      if (this.goServer.pid) {
-        spawn('taskkill', ['/pid', String(this.goServer.pid), '/f', '/t']);
+    return new Promise((resolve) => {
+      if (child.exitCode !== null || child.signalCode !== null) {
+        resolve();
+        return;
       }
-    } else {
-      // Unix: send SIGTERM for graceful shutdown
-      this.goServer.kill('SIGTERM');
-      
+
       // Force kill after timeout
-      setTimeout(() => {
-        if (this.goServer && !this.goServer.killed) {
-          logger.warn('Force killing Go Server');
-          this.goServer.kill('SIGKILL');
+      const timer = setTimeout(() => {
+        logger.warn('Go Server did not exit in time, force killing', { pid: child.pid });
+        if (process.platform === 'win32') {
+          if (child.pid) {
+            spawn('taskkill', ['/pid', String(child.pid), '/f', '/t']);
+          }
+        } else {
+          child.kill('SIGKILL');
         }
-      }, 5000);
-    }
+      }, config.goServer.shutdownTimeout);
 
-    this.goServer = null;
+      child.once('exit', () => {
+        clearTimeout(timer);
+        resolve();
+      });
+
+      // Closing stdin starts a graceful shutdown on every platform
+      child.stdin?.end();
+    });
   }
 
   /**
@@ -274,8 +339,7 @@ export class ProcessManager extends EventEmitter {
    */
   async restart(): Promise<void> {
     logger.info('Restarting Go Server');
-    this.stop();
-    await this.sleep(1000);
+    await this.stop();
     this.restartCount = 0;
     await this.start();
   }
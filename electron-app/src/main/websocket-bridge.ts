@@ -18,9 +18,11 @@ export class WebSocketBridge {
   private isConnecting = false;
   private shouldReconnect = true;
   private mainWindow: BrowserWindow;
+  private getToken: () => string;
 
-  constructor(mainWindow: BrowserWindow) {
+  constructor(mainWindow: BrowserWindow, getToken: () => string) {
     this.mainWindow = mainWindow;
+    this.getToken = getToken;
   }
 
   /**
@@ -43,7 +45,9 @@ export class WebSocketBridge {
 
     logger.info('Connecting to Go Server', { url: config.websocket.url });
 
-    this.ws = new WebSocket(config.websocket.url);
+    this.ws = new WebSocket(config.websocket.url, {
+      headers: { Authorization: `Bearer ${this.getToken()}` },
+    });
 
     this.ws.on('open', () => {
       this.isConnecting = false;
@@ -9,12 +9,25 @@
 import { useEffect, useCallback, useState, useRef } from 'react';
 import type { ServerStateString, TransactionResult } from './useAppState';
 
+// ============ Amounts ============
+
+// The keypad works in cents; the server takes and returns decimal amounts
+// ("1.00" is NT$1.00).
+export function centsToAmount(cents: string): string {
+  return (parseInt(cents || '0') / 100).toFixed(2);
+}
+
+export function amountToCents(amount?: string): number {
+  return Math.round(parseFloat((amount || '0').replace(/,/g, '')) * 100) || 0;
+}
+
 // ============ Types ============
 
 export interface POSResponse {
-  status: 'processing' | 'success' | 'error' | 'status_update';
+  status: 'processing' | 'success' | 'error' | 'unknown' | 'reconciled' | 'status_update';
   message: string;
-  command_type?: 'transaction' | 'control' | 'status';
+  command_type?: 'transaction' | 'control' | 'status' | 'reconciliation';
+  transaction_id?: string;
   data?: {
     TransType?: string;
     Amount?: string;
@@ -31,6 +44,31 @@ export interface POSResponse {
   };
 }
 
+// Data of a 'reconciled' message: the real outcome of a transaction that was
+// reported as unknown, from a late terminal response or an operator
+interface ReconciledTransaction {
+  transaction_id: string;
+  trans_type?: string;
+  amount?: string;
+  order_no?: string;
+  outcome: 'APPROVED' | 'DECLINED';
+  result?: Record<string, string>;
+}
+
+// reconciledResult takes the terminal's late response, or what the server
+// knows of the request when an operator resolved it
+function reconciledResult(tx: ReconciledTransaction): TransactionResult {
+  const r: Record<string, string> = tx.result ?? {};
+  return {
+    TransType: r.TransType ?? (tx.trans_type === 'SALE' ? '01' : '02'),
+    Amount: r.Amount ?? tx.amount,
+    ApprovalNo: r.ApprovalNo,
+    OrderNo: r.OrderNo ?? tx.order_no,
+    CardNo: r.CardNo,
+    RespCode: r.RespCode,
+  };
+}
+
 export interface POSCallbacks {
   onConnect: () => void;
   onDisconnect: () => void;
@@ -43,6 +81,13 @@ export interface POSCallbacks {
   ) => void;
   onTransactionSuccess: (result: TransactionResult) => void;
   onTransactionError: (error: string, result?: TransactionResult) => void;
+  onTransactionUnknown: (message: string, transactionId?: string) => void;
+  onTransactionReconciled: (
+    transactionId: string,
+    approved: boolean,
+    message: string,
+    result: TransactionResult
+  ) => void;
 }
 
 // ============ Helpers ============
@@ -116,6 +161,27 @@ export function usePOS(callbacks: POSCallbacks) {
           } : undefined);
         }
         break;
+
+      case 'unknown':
+        // Sent but never answered: the card may have been charged
+        if (resp.command_type === 'transaction') {
+          callbacksRef.current.onTransactionUnknown(resp.message, resp.transaction_id);
+        }
+        break;
+
+      case 'reconciled': {
+        // The real outcome of an unknown transaction, possibly minutes later
+        if (resp.data) {
+          const tx = resp.data as unknown as ReconciledTransaction;
+          callbacksRef.current.onTransactionReconciled(
+            tx.transaction_id,
+            tx.outcome === 'APPROVED',
+            resp.message,
+            reconciledResult(tx)
+          );
+        }
+        break;
+      }
     }
   }, [addLog]);
 
@@ -210,8 +276,8 @@ export function usePOS(callbacks: POSCallbacks) {
   // Send transaction command
   const sendTransaction = useCallback(
     async (command: 'SALE' | 'REFUND', amount: string, orderNo?: string) => {
-      const message = { command, amount, order_no: orderNo };
-      addLog(`Sending ${command}: $${(parseInt(amount) / 100).toFixed(2)}`);
+      const message = { command, amount: centsToAmount(amount), order_no: orderNo };
+      addLog(`Sending ${command}: $${centsToAmount(amount)}`);
 
       if (isElectron()) {
         const result = await window.electronAPI.ws.send(message);
@@ -368,7 +368,7 @@ func parseRequestInfo(packet []byte) RequestInfo {
 	transType := readField(0, 2)
 	transName := map[string]string{
 		"01": "SALE", "02": "REFUND", "10": "PREAUTH",
-		"11": "AUTH_COMPLETE", "50": "SETTLEMENT", "80": "ECHO",
+		"11": "AUTH_COMPLETE", "50": "SETTLEMENT", "60": "VOID", "80": "ECHO",
 	}[transType]
 	if transName == "" {
 		transName = transType
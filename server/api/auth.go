@@ -0,0 +1,103 @@
+package api
+
+import (
+	"ecpay-server/auth"
+	"ecpay-server/logger"
+	"ecpay-server/protocol"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// SetAuthenticator replaces the origin allowlist and credential checks
+func (h *Handler) SetAuthenticator(a *auth.Authenticator) {
+	h.auth.Store(a)
+}
+
+// authenticate identifies the caller of a WebSocket or REST request. On
+// failure it writes 403 (origin not allowed) or 401 and returns false.
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (Caller, bool) {
+	principal, err := h.auth.Load().Authenticate(r)
+	if err != nil {
+		logger.Warn("Rejected %s %s from %s (origin %q): %v", r.Method, r.URL.Path, r.RemoteAddr, r.Header.Get("Origin"), err)
+		status := http.StatusUnauthorized
+		if errors.Is(err, auth.ErrOriginForbidden) {
+			status = http.StatusForbidden
+		}
+		writeJSON(w, status, controlResponse("error", err.Error(), nil))
+		return Caller{}, false
+	}
+	return Caller{Client: r.RemoteAddr, Principal: principal}, true
+}
+
+// Error codes reported in WebResponse.Code when authorization fails
+const (
+	CodeForbidden        = "FORBIDDEN"         // Role not allowed to run the command
+	CodeApprovalRequired = "APPROVAL_REQUIRED" // Refund above threshold without approval
+	CodeApprovalDenied   = "APPROVAL_DENIED"   // Approval credential invalid or not an approver
+)
+
+// Approval carries a second credential authorizing a refund above the
+// approval threshold, or a transaction over its amount limits
+type Approval struct {
+	Token string `json:"token"` // Pre-shared token of a principal with an approver role
+}
+
+// SetPolicy sets the command/role policy. nil disables role checks.
+func (h *Handler) SetPolicy(p *auth.Policy) {
+	h.policy.Store(p)
+}
+
+// authorize checks the caller's roles against the policy
+func (h *Handler) authorize(caller Caller, command string) (WebResponse, bool) {
+	if h.policy.Load().Allowed(caller.Principal, command) {
+		return WebResponse{}, true
+	}
+	logger.Audit("DENIED command=%s principal=%s roles=%v client=%s code=%s",
+		command, caller.Principal, caller.Principal.Roles, caller.Client, CodeForbidden)
+	return WebResponse{
+		Status:  "error",
+		Message: "not permitted: " + command,
+		Code:    CodeForbidden,
+	}, false
+}
+
+// checkApproval enforces supervisor approval for a refund or void above the
+// threshold. It returns the approving principal's name, if any.
+func (h *Handler) checkApproval(tx logger.Txn, caller Caller, req WebRequest, amount protocol.Money) (string, *WebResponse) {
+	if !h.policy.Load().NeedsApproval(req.Command, int64(amount)) || h.policy.Load().CanApprove(caller.Principal) {
+		return "", nil
+	}
+
+	deny := func(code, msg string) (string, *WebResponse) {
+		logger.Audit("DENIED command=%s txn=%s principal=%s client=%s amount=%s code=%s: %s",
+			req.Command, tx.ID, caller.Principal, caller.Client, amount, code, msg)
+		return "", &WebResponse{Status: "error", Message: msg, Code: code}
+	}
+
+	if req.Approval == nil || req.Approval.Token == "" {
+		return deny(CodeApprovalRequired, fmt.Sprintf("%s above %s requires supervisor approval",
+			req.Command, protocol.Money(h.policy.Load().RefundApprovalThreshold)))
+	}
+	approver, err := h.verifyApproval(caller, req.Approval)
+	if err != nil {
+		return deny(CodeApprovalDenied, err.Error())
+	}
+
+	logger.Audit("APPROVED command=%s txn=%s principal=%s approver=%s amount=%s",
+		req.Command, tx.ID, caller.Principal, approver, amount)
+	return approver.Name, nil
+}
+
+// verifyApproval checks that an approval credential belongs to an approver
+// other than the caller
+func (h *Handler) verifyApproval(caller Caller, approval *Approval) (auth.Principal, error) {
+	approver, err := h.auth.Load().AuthenticateToken(approval.Token)
+	if err != nil {
+		return auth.Principal{}, fmt.Errorf("approval rejected: %v", err)
+	}
+	if approver.Name == caller.Principal.Name || !h.policy.Load().CanApprove(approver) {
+		return auth.Principal{}, fmt.Errorf("approval rejected: %s cannot approve this request", approver.Name)
+	}
+	return approver, nil
+}
@@ -0,0 +1,454 @@
+package api
+
+import (
+	"ecpay-server/auth"
+	"ecpay-server/certs"
+	"ecpay-server/driver"
+	"ecpay-server/hooks"
+	"ecpay-server/ledger"
+	"ecpay-server/logger"
+	"ecpay-server/protocol"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// msgBusy is returned when a transaction is already running
+const msgBusy = "POS is busy"
+
+// CodeInvalidRequest is the error code of transaction commands with a
+// malformed amount
+const CodeInvalidRequest = "INVALID_REQUEST"
+
+// resultRetention is how long finished results stay available for polling
+const resultRetention = 1 * time.Hour
+
+// Caller identifies who issued a command, independent of transport
+type Caller struct {
+	Client    string         // Remote address
+	Principal auth.Principal // Authenticated identity
+}
+
+// ServerStatus is the STATUS response: terminal/transaction status plus
+// server details
+type ServerStatus struct {
+	driver.StatusInfo
+	TLS *certs.Info `json:"tls,omitempty"` // Served certificate, if TLS is enabled
+}
+
+// IsTransactionCommand reports whether a command talks to the terminal and
+// may run for up to a minute
+func IsTransactionCommand(command string) bool {
+	switch command {
+	case "SALE", "REFUND", "VOID", "SETTLEMENT", "ECHO":
+		return true
+	}
+	return false
+}
+
+// normalizeAmount validates the amount of a transaction command and rewrites
+// it in canonical decimal form ("1,250" -> "1250.00"), so that retries,
+// ledger records and refund checks all compare the same value. SETTLEMENT
+// and ECHO carry no amount; a VOID without one defaults to the sale amount.
+func normalizeAmount(req *WebRequest) error {
+	switch req.Command {
+	case "SETTLEMENT", "ECHO":
+		req.Amount = ""
+		return nil
+	case "VOID":
+		if req.Amount == "" {
+			return nil
+		}
+	}
+	amount, err := protocol.ParseAmount(req.Amount)
+	if err != nil {
+		return err
+	}
+	if amount == 0 {
+		return fmt.Errorf("amount must be greater than zero")
+	}
+	req.Amount = amount.String()
+	return nil
+}
+
+// storedResult is a transaction response kept for polling
+type storedResult struct {
+	resp     WebResponse
+	storedAt time.Time
+}
+
+// resultStore keeps recent transaction responses by transaction ID so that
+// asynchronous callers can poll for them
+type resultStore struct {
+	mu      sync.Mutex
+	results map[string]storedResult
+}
+
+func newResultStore() *resultStore {
+	return &resultStore{results: make(map[string]storedResult)}
+}
+
+func (s *resultStore) put(txnID string, resp WebResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, r := range s.results {
+		if now.Sub(r.storedAt) > resultRetention {
+			delete(s.results, id)
+		}
+	}
+	s.results[txnID] = storedResult{resp: resp, storedAt: now}
+}
+
+func (s *resultStore) get(txnID string) (WebResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.results[txnID]
+	return r.resp, ok
+}
+
+// Execute runs a command and returns its final response. It is the single
+// command layer shared by the WebSocket and REST transports. Transaction
+// commands block until the POS answers; progress, if non-nil, receives
+// intermediate "processing" responses.
+func (h *Handler) Execute(caller Caller, req WebRequest, progress func(WebResponse)) WebResponse {
+	if resp, ok := h.authorize(caller, req.Command); !ok {
+		resp.CommandType = "control"
+		if IsTransactionCommand(req.Command) {
+			resp.CommandType = "transaction"
+		}
+		resp.RequestID = req.RequestID
+		return resp
+	}
+
+	if IsTransactionCommand(req.Command) {
+		if err := normalizeAmount(&req); err != nil {
+			return WebResponse{Status: "error", Message: err.Error(), Code: CodeInvalidRequest, CommandType: "transaction", RequestID: req.RequestID}
+		}
+		if !h.enter() {
+			return shuttingDown("transaction", req)
+		}
+		defer h.leave()
+		for {
+			txnID, prior, err := h.beginTransaction(req)
+			if err != nil {
+				return beginFailed(req, err)
+			}
+			if prior == nil {
+				return h.executeTransaction(caller, txnID, req)
+			}
+			// Retry of a known request: attach to it instead of running again
+			<-prior.done
+			if !prior.released {
+				return h.replay(prior, req)
+			}
+		}
+	}
+
+	if (req.Command == "RECONNECT" || req.Command == "RESTART" || req.Command == "RESTART_PROCESS") && h.isDraining() {
+		return shuttingDown("control", req)
+	}
+
+	logger.Info("%s requested by %s (%s)", req.Command, caller.Principal, caller.Client)
+	resp := h.executeControl(caller, req, progress)
+	resp.RequestID = req.RequestID
+	return resp
+}
+
+// executeControl runs a non-transaction command
+func (h *Handler) executeControl(caller Caller, req WebRequest, progress func(WebResponse)) WebResponse {
+	switch req.Command {
+	case "STATUS":
+		status := ServerStatus{StatusInfo: h.Manager().GetStatus()}
+		if h.certs != nil {
+			info := h.certs.Info()
+			status.TLS = &info
+		}
+		return WebResponse{Status: "status_update", Message: status.Message, CommandType: "status", Data: status}
+	case "ABORT":
+		if h.Manager().AbortTransaction() {
+			return controlResponse("success", "Transaction aborted", nil)
+		}
+		return controlResponse("error", "No transaction to abort", nil)
+	case "RECONNECT":
+		if progress != nil {
+			resp := controlResponse("processing", "Reconnecting to POS...", nil)
+			resp.RequestID = req.RequestID
+			progress(resp)
+		}
+		if err := h.Manager().Reconnect(); err != nil {
+			return controlResponse("error", err.Error(), nil)
+		}
+		return controlResponse("success", "Reconnected to POS", nil)
+	case "UNRESOLVED":
+		list := h.Manager().UnresolvedTransactions()
+		return WebResponse{
+			Status:      "success",
+			Message:     fmt.Sprintf("%d unresolved transactions", len(list)),
+			CommandType: "reconciliation",
+			Data:        list,
+		}
+	case "RESOLVE":
+		return h.resolve(caller, req)
+	case "HISTORY":
+		var q HistoryQuery
+		if req.Query != nil {
+			q = *req.Query
+		}
+		records, err := h.queryHistory(q)
+		if err != nil {
+			return WebResponse{Status: "error", Message: err.Error(), CommandType: "history"}
+		}
+		return WebResponse{
+			Status:      "success",
+			Message:     fmt.Sprintf("%d transactions", len(records)),
+			CommandType: "history",
+			Data:        records,
+		}
+	case "HELLO":
+		return h.hello(caller, req.ProtocolVersion)
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		return controlResponse("error", req.Command+" is only available on WebSocket connections", nil)
+	case "RESTART":
+		return h.softRestart(caller)
+	case "RESTART_PROCESS":
+		logger.Info("RESTART_PROCESS command received from %s (%s) - triggering server restart", caller.Principal, caller.Client)
+		// Shut down gracefully and exit, expecting the process manager to
+		// restart the server
+		h.requestShutdown("restart")
+		return controlResponse("processing", "Server restarting...", nil)
+	default:
+		return controlResponse("error", "Unknown Command", nil)
+	}
+}
+
+// StartTransaction runs a transaction command in the background and returns
+// a "processing" response with its transaction ID immediately; the result can
+// be polled with Result. A retry of a finished idempotent request returns the
+// stored result instead.
+func (h *Handler) StartTransaction(caller Caller, req WebRequest) WebResponse {
+	if resp, ok := h.authorize(caller, req.Command); !ok {
+		resp.CommandType = "transaction"
+		resp.RequestID = req.RequestID
+		return resp
+	}
+	if err := normalizeAmount(&req); err != nil {
+		return WebResponse{Status: "error", Message: err.Error(), Code: CodeInvalidRequest, CommandType: "transaction", RequestID: req.RequestID}
+	}
+	if !h.enter() {
+		return shuttingDown("transaction", req)
+	}
+
+	var txnID string
+	for {
+		id, prior, err := h.beginTransaction(req)
+		if err != nil {
+			h.leave()
+			return beginFailed(req, err)
+		}
+		if prior == nil {
+			txnID = id
+			break
+		}
+		if !prior.finished() {
+			// Still running: point the caller at it
+			h.leave()
+			return WebResponse{
+				Status:        "processing",
+				Message:       "Transaction in progress",
+				CommandType:   "transaction",
+				TransactionID: prior.txnID,
+				RequestID:     req.RequestID,
+				Replayed:      true,
+			}
+		}
+		if !prior.released {
+			h.leave()
+			return h.replay(prior, req)
+		}
+		// It never reached the terminal, so the key is free: run this one,
+		// as Execute does
+	}
+	go func() {
+		defer h.leave()
+		h.executeTransaction(caller, txnID, req)
+	}()
+	resp, _ := h.Result(txnID)
+	return resp
+}
+
+// beginTransaction assigns a transaction ID and claims the request's
+// idempotency key. prior is set if the key belongs to an earlier request.
+func (h *Handler) beginTransaction(req WebRequest) (txnID string, prior *idemEntry, err error) {
+	txnID = driver.NewTransactionID()
+	prior, err = h.claimIdempotencyKey(txnID, req)
+	if err != nil || prior != nil {
+		return "", prior, err
+	}
+	h.results.put(txnID, WebResponse{
+		Status:        "processing",
+		Message:       "Transaction in progress",
+		CommandType:   "transaction",
+		TransactionID: txnID,
+		RequestID:     req.RequestID,
+	})
+	return txnID, nil, nil
+}
+
+// beginFailed is the response to a transaction beginTransaction refused
+func beginFailed(req WebRequest, err error) WebResponse {
+	resp := WebResponse{Status: "error", Message: err.Error(), CommandType: "transaction", RequestID: req.RequestID}
+	if errors.Is(err, errIdempotencyConflict) {
+		resp.Code = CodeIdempotencyConflict
+	}
+	return resp
+}
+
+// Result returns the response of a recent transaction
+func (h *Handler) Result(txnID string) (WebResponse, bool) {
+	return h.results.get(txnID)
+}
+
+func controlResponse(status, message string, data interface{}) WebResponse {
+	return WebResponse{Status: status, Message: message, CommandType: "control", Data: data}
+}
+
+// executeTransaction builds, validates and runs a transaction command
+func (h *Handler) executeTransaction(caller Caller, txnID string, req WebRequest) WebResponse {
+	resp, sent := h.runTransaction(caller, txnID, req)
+	resp.CommandType = "transaction"
+	resp.TransactionID = txnID
+	resp.RequestID = req.RequestID
+	h.results.put(txnID, resp)
+	h.completeIdempotencyKey(txnID, req, resp, sent)
+	return resp
+}
+
+// checkReversal resolves the original order of a REFUND or VOID and
+// validates it before touching the terminal. Large reversals need a
+// supervisor, whose name is returned.
+func (h *Handler) checkReversal(tx logger.Txn, caller Caller, req *WebRequest) (string, *WebResponse) {
+	if _, err := h.checkRefund(tx, req); err != nil {
+		tx.Warn("%s rejected: OrderNo=%s MerchantOrderID=%s Amount=%s: %v",
+			req.Command, req.OrderNo, req.MerchantOrderID, req.Amount, err)
+		var rejected *RefundRejectedError
+		if errors.As(err, &rejected) {
+			return "", &WebResponse{Status: "error", Message: err.Error(), Code: CodeRefundRejected, Data: rejected.Check}
+		}
+		return "", &WebResponse{Status: "error", Message: err.Error()}
+	}
+	amount, _ := protocol.ParseAmount(req.Amount)
+	return h.checkApproval(tx, caller, *req, amount)
+}
+
+// runTransaction runs a transaction command. sent reports whether the
+// request may have reached the terminal.
+func (h *Handler) runTransaction(caller Caller, txnID string, req WebRequest) (resp WebResponse, sent bool) {
+	tx := logger.Txn{ID: txnID, RequestID: req.RequestID}
+	tx.Info("%s requested by %s (%s)", req.Command, caller.Principal, caller.Client)
+
+	// Try to lock for transaction
+	if !h.mu.TryLock() {
+		tx.Warn("%s rejected: %s", req.Command, msgBusy)
+		return WebResponse{Status: "error", Message: msgBusy}, false
+	}
+	defer h.mu.Unlock()
+
+	// Build Protocol Request
+	var ecpayReq protocol.ECPayRequest
+	var approvedBy string
+
+	switch req.Command {
+	case "SALE":
+		ecpayReq.TransType = "01"
+		ecpayReq.HostID = "01"
+		ecpayReq.Amount, _ = protocol.ParseAmount(req.Amount)
+	case "REFUND", "VOID":
+		var denied *WebResponse
+		if approvedBy, denied = h.checkReversal(tx, caller, &req); denied != nil {
+			return *denied, false
+		}
+		ecpayReq.TransType = "02"
+		if req.Command == "VOID" {
+			ecpayReq.TransType = "60"
+		}
+		ecpayReq.HostID = "01"
+		ecpayReq.Amount, _ = protocol.ParseAmount(req.Amount)
+		ecpayReq.OrderNo = req.OrderNo
+	case "SETTLEMENT":
+		ecpayReq.TransType = "50"
+		ecpayReq.HostID = "01"
+	case "ECHO":
+		ecpayReq.TransType = "80"
+		ecpayReq.HostID = "01"
+	}
+
+	// Site-specific hooks may veto the transaction, adjust it or tag it
+	ht := hooks.Transaction{
+		ID:              txnID,
+		RequestID:       req.RequestID,
+		Command:         req.Command,
+		Principal:       caller.Principal.Name,
+		Client:          caller.Client,
+		MerchantOrderID: req.MerchantOrderID,
+		Request:         ecpayReq,
+	}
+	unhooked := ecpayReq
+	if denied := h.runBeforeHooks(tx, &ht, &req, &ecpayReq); denied != nil {
+		return *denied, false
+	}
+	if (req.Command == "REFUND" || req.Command == "VOID") &&
+		(ecpayReq.Amount != unhooked.Amount || ecpayReq.OrderNo != unhooked.OrderNo) {
+		// The hook changed what is reversed: check it again
+		var denied *WebResponse
+		if approvedBy, denied = h.checkReversal(tx, caller, &req); denied != nil {
+			return *denied, false
+		}
+		ecpayReq.Amount, _ = protocol.ParseAmount(req.Amount)
+		ecpayReq.OrderNo = req.OrderNo
+		ht.Request = ecpayReq
+	}
+
+	// Amount limits, checked against today's ledger totals
+	switch req.Command {
+	case "SALE", "REFUND", "VOID":
+		override, denied := h.checkLimits(tx, caller, req, ecpayReq.Amount)
+		if denied != nil {
+			return *denied, false
+		}
+		if approvedBy == "" {
+			approvedBy = override
+		}
+	}
+
+	// Execute transaction
+	h.recordStart(tx, caller, req, ecpayReq.TransType, approvedBy, ht.Tags)
+	started := newTransactionEvent(tx, caller, req, nil, nil)
+	started.Status = ledger.StatusPending
+	started.Tags = ht.Tags
+	h.pushTransaction("transaction_started", req.Command+" started", started)
+
+	result, err := h.Manager().ExecuteTransaction(txnID, req.RequestID, ecpayReq)
+	h.recordResult(tx, req.Command, result, err)
+	event := newTransactionEvent(tx, caller, req, result, err)
+	event.Tags = ht.Tags
+	// Let clients see the final status updates before the result
+	h.clientEvents.Flush()
+	h.pushTransaction("transaction_completed", req.Command+" "+event.Status, event)
+	h.publishTransaction(tx, event)
+	h.runAfterHooks(ht, event)
+	sent = !errors.Is(err, driver.ErrNotConnected) && !errors.Is(err, driver.ErrTransactionInProgress)
+	if err != nil {
+		// Sent but never answered: the card may still have been charged
+		var unknown *driver.UnknownOutcomeError
+		if errors.As(err, &unknown) {
+			return WebResponse{Status: "unknown", Message: err.Error(), Data: unknown.Transaction}, sent
+		}
+		return WebResponse{Status: "error", Message: err.Error(), Data: result}, sent
+	}
+
+	// Success
+	return WebResponse{Status: "success", Message: "Transaction Approved", Data: result}, sent
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"ecpay-server/logger"
+	"ecpay-server/metrics"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// eventLogSize is how many recent events are kept for Last-Event-ID
+	// resume
+	eventLogSize = 1000
+
+	// eventStreamBuffer is how many events an event stream client may fall
+	// behind
+	eventStreamBuffer = 64
+)
+
+// streamEvent is a pushed event with its position in the event log
+type streamEvent struct {
+	id         uint64
+	topic      string
+	terminalID string
+	resp       WebResponse
+}
+
+// eventID is a position in the event log. Sequence numbers start over when
+// the server does, so IDs carry the epoch of the run that issued them:
+// "<epoch>-<seq>".
+type eventID struct {
+	epoch string
+	seq   uint64
+}
+
+func (id eventID) String() string {
+	return id.epoch + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+// parseEventID parses a Last-Event-ID. IDs of older versions, plain
+// sequence numbers, parse with an empty epoch.
+func parseEventID(s string) (eventID, error) {
+	epoch, seq, found := strings.Cut(s, "-")
+	if !found {
+		epoch, seq = "", s
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return eventID{}, fmt.Errorf("invalid event ID %q", s)
+	}
+	return eventID{epoch: epoch, seq: n}, nil
+}
+
+// Reasons a resumed event stream missed events
+const (
+	lostGap   = "gap"   // Older than the events still held
+	lostReset = "reset" // From before the server restarted
+)
+
+// resumption is what a stream resuming from a Last-Event-ID missed
+type resumption struct {
+	replay []streamEvent // Missed events the stream wants, oldest first
+	lost   string        // lostGap or lostReset if some cannot be replayed
+	head   eventID       // Newest event at the time of subscribing
+}
+
+// eventStream is one Server-Sent Events client
+type eventStream struct {
+	ch  chan streamEvent
+	sub subscription
+}
+
+// eventLog numbers pushed events, keeps the most recent ones for resume and
+// hands them to event stream clients. Like the hub it never blocks the
+// caller.
+type eventLog struct {
+	epoch   string // Distinguishes this run's event IDs from earlier runs'
+	mu      sync.Mutex
+	seq     uint64
+	events  []streamEvent // Ring buffer of the last eventLogSize events
+	next    int
+	streams map[*eventStream]struct{}
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		streams: make(map[*eventStream]struct{}),
+	}
+}
+
+// id returns the event ID of sequence number seq
+func (l *eventLog) id(seq uint64) eventID {
+	return eventID{epoch: l.epoch, seq: seq}
+}
+
+// append records an event and queues it for subscribed streams. A stream
+// that falls behind skips status updates; if it misses anything else it is
+// closed, and the client resumes from the log with Last-Event-ID.
+func (l *eventLog) append(topic, terminalID string, resp WebResponse) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	ev := streamEvent{id: l.seq, topic: topic, terminalID: terminalID, resp: resp}
+	if len(l.events) < eventLogSize {
+		l.events = append(l.events, ev)
+	} else {
+		l.events[l.next] = ev
+	}
+	l.next = (l.next + 1) % eventLogSize
+
+	for s := range l.streams {
+		if !s.sub.wants(topic, terminalID) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			if topic == TopicStatus {
+				metrics.WebSocketDropped.Inc()
+				continue
+			}
+			delete(l.streams, s)
+			close(s.ch)
+		}
+	}
+}
+
+// subscribe registers a stream. With from set it also returns the logged
+// events after from that the stream wants, and whether any were lost: from
+// is older than the log, or from another run.
+func (l *eventLog) subscribe(sub Subscription, from *eventID) (*eventStream, resumption) {
+	s := &eventStream{ch: make(chan streamEvent, eventStreamBuffer)}
+	s.sub.set(sub)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	res := resumption{head: l.id(l.seq)}
+	switch {
+	case from == nil:
+	case from.epoch != l.epoch || from.seq > l.seq:
+		res.lost = lostReset
+	default:
+		n := len(l.events)
+		for i := 0; i < n; i++ {
+			// Walk oldest to newest
+			ev := l.events[(l.next+i)%n]
+			if i == 0 && ev.id > from.seq+1 {
+				res.lost = lostGap
+			}
+			if ev.id > from.seq && s.sub.wants(ev.topic, ev.terminalID) {
+				res.replay = append(res.replay, ev)
+			}
+		}
+	}
+	l.streams[s] = struct{}{}
+	metrics.EventStreamClients.Set(float64(len(l.streams)))
+	return s, res
+}
+
+// unsubscribe removes a stream, if append has not already dropped it
+func (l *eventLog) unsubscribe(s *eventStream) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.streams[s]; ok {
+		delete(l.streams, s)
+		close(s.ch)
+	}
+	metrics.EventStreamClients.Set(float64(len(l.streams)))
+}
+
+// hasSubscribers reports whether any stream is subscribed to topic
+func (l *eventLog) hasSubscribers(topic string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for s := range l.streams {
+		if s.sub.wants(topic, "") {
+			return true
+		}
+	}
+	return false
+}
+
+// serveEvents handles GET /api/v1/events: the pushed WebSocket events as a
+// Server-Sent Events stream. Query: topics (default status) and
+// terminal_id, as on the WebSocket. Each event is named after the message
+// status (status_update, transaction_completed, ...) and its data is the
+// message. A reconnecting client's Last-Event-ID replays what it missed.
+func (h *Handler) serveEvents(w http.ResponseWriter, r *http.Request) {
+	caller, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	sub, resp, ok := h.initialSubscription(caller, r)
+	if ok && slices.Contains(sub.Topics, TopicLogs) {
+		resp, ok = controlResponse("error", "topic logs is served by /api/v1/logs", nil), false
+	}
+	if !ok {
+		status := http.StatusBadRequest
+		if resp.Code == CodeForbidden {
+			status = http.StatusForbidden
+		}
+		writeJSON(w, status, resp)
+		return
+	}
+
+	var from *eventID
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID != "" {
+		id, err := parseEventID(lastID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, controlResponse("error", "invalid Last-Event-ID: "+lastID, nil))
+			return
+		}
+		from = &id
+	}
+
+	es, res := h.events.subscribe(sub, from)
+	defer h.events.unsubscribe(es)
+
+	stream, ok := startSSE(w)
+	if !ok {
+		return
+	}
+	logger.Info("Event stream opened by %s (%s) topics=[%s] after=%q",
+		caller.Principal, caller.Client, strings.Join(sub.Topics, ", "), lastID)
+	defer logger.Info("Event stream closed by %s (%s)", caller.Principal, caller.Client)
+
+	switch res.lost {
+	case lostGap:
+		// Events were lost; the client should refresh its state
+		gap := controlResponse("error", fmt.Sprintf("events after %s are no longer available", lastID), nil)
+		if err := stream.event("", lostGap, gap); err != nil {
+			return
+		}
+	case lostReset:
+		// The server restarted; nothing before now can be replayed. The ID
+		// lets the client resume from here next time.
+		reset := controlResponse("error", fmt.Sprintf("server restarted since event %s", lastID), nil)
+		if err := stream.event(res.head.String(), lostReset, reset); err != nil {
+			return
+		}
+	}
+	// Like a new WebSocket client, start with the current status
+	if slices.Contains(sub.Topics, TopicStatus) {
+		status := h.Manager().GetStatus()
+		current := WebResponse{Status: "status_update", Message: status.Message, CommandType: "status", Data: status, Topic: TopicStatus}
+		if err := stream.event("", current.Status, current); err != nil {
+			return
+		}
+	}
+	for _, ev := range res.replay {
+		if err := stream.event(h.events.id(ev.id).String(), ev.resp.Status, ev.resp); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.done:
+			return
+		case <-keepAlive.C:
+			if err := stream.ping(); err != nil {
+				return
+			}
+		case ev, ok := <-es.ch:
+			if !ok {
+				// Dropped for falling behind; the client reconnects and resumes
+				return
+			}
+			if err := stream.event(h.events.id(ev.id).String(), ev.resp.Status, ev.resp); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import "testing"
+
+func TestEventStreamResume(t *testing.T) {
+	l := newEventLog()
+	sub := Subscription{Topics: []string{TopicTransactions}}
+	for i := 0; i < 3; i++ {
+		l.append(TopicTransactions, "", WebResponse{Status: "transaction_completed"})
+	}
+
+	resume := func(lastID string) resumption {
+		t.Helper()
+		id, err := parseEventID(lastID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, res := l.subscribe(sub, &id)
+		l.unsubscribe(s)
+		return res
+	}
+
+	// Same run: the missed events are replayed
+	res := resume(l.id(1).String())
+	if res.lost != "" || len(res.replay) != 2 || res.replay[0].id != 2 {
+		t.Fatalf("resume from 1: lost %q, replayed %d; want events 2 and 3", res.lost, len(res.replay))
+	}
+
+	// IDs of an earlier run, or of a version without epochs, are not
+	// replayed from this run's log
+	for _, lastID := range []string{"lrx0a2b3-2", "2"} {
+		res := resume(lastID)
+		if res.lost != lostReset || len(res.replay) != 0 || res.head != l.id(3) {
+			t.Fatalf("resume from %s: lost %q, replayed %d, head %s; want a reset at %s",
+				lastID, res.lost, len(res.replay), res.head, l.id(3))
+		}
+	}
+
+	// Older than the log holds: a gap, then everything still held
+	for i := 0; i < eventLogSize; i++ {
+		l.append(TopicTransactions, "", WebResponse{Status: "transaction_completed"})
+	}
+	res = resume(l.id(2).String())
+	if res.lost != lostGap || len(res.replay) != eventLogSize || res.replay[0].id != 4 {
+		t.Fatalf("resume from 2: lost %q, replayed %d; want a gap and %d events from 4", res.lost, len(res.replay), eventLogSize)
+	}
+
+	if _, err := parseEventID(l.epoch + "-x"); err == nil {
+		t.Fatal("parseEventID accepted a non-numeric sequence")
+	}
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"ecpay-server/auth"
+	"ecpay-server/driver"
+	"ecpay-server/ledger"
+	"ecpay-server/logger"
+	"ecpay-server/terminalpb"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcCommands maps gRPC transaction commands to WebSocket commands
+var grpcCommands = map[terminalpb.Command]string{
+	terminalpb.Command_COMMAND_SALE:       "SALE",
+	terminalpb.Command_COMMAND_REFUND:     "REFUND",
+	terminalpb.Command_COMMAND_VOID:       "VOID",
+	terminalpb.Command_COMMAND_SETTLEMENT: "SETTLEMENT",
+	terminalpb.Command_COMMAND_ECHO:       "ECHO",
+}
+
+// grpcService implements terminalpb.TerminalGatewayServer on top of the same
+// command layer as the WebSocket and REST endpoints
+type grpcService struct {
+	terminalpb.UnimplementedTerminalGatewayServer
+	h *Handler
+}
+
+// callerKey carries the authenticated Caller in a request context
+type callerKey struct{}
+
+// NewGRPCServer returns a gRPC server exposing the TerminalGateway service.
+// With certs set (SetCertificates) it serves TLS with the same certificate
+// as the WebSocket listener.
+func (h *Handler) NewGRPCServer() *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(h.grpcUnaryAuth),
+		grpc.StreamInterceptor(h.grpcStreamAuth),
+	}
+	if h.certs != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(h.certs.TLSConfig())))
+	}
+	s := grpc.NewServer(opts...)
+	terminalpb.RegisterTerminalGatewayServer(s, &grpcService{h: h})
+	return s
+}
+
+// grpcAuthenticate identifies the caller from the "authorization: Bearer
+// <token>" metadata
+func (h *Handler) grpcAuthenticate(ctx context.Context, method string) (context.Context, error) {
+	caller := Caller{Principal: auth.Principal{Name: "anonymous", Method: "none"}}
+	if p, ok := peer.FromContext(ctx); ok {
+		caller.Client = p.Addr.String()
+	}
+	if !h.auth.Load().AllowsAnonymous() {
+		var token string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("authorization"); len(values) > 0 {
+				token, _ = strings.CutPrefix(values[0], "Bearer ")
+			}
+		}
+		if token == "" {
+			logger.Warn("Rejected gRPC %s from %s: %v", method, caller.Client, auth.ErrUnauthorized)
+			return nil, status.Error(codes.Unauthenticated, auth.ErrUnauthorized.Error())
+		}
+		principal, err := h.auth.Load().AuthenticateToken(token)
+		if err != nil {
+			logger.Warn("Rejected gRPC %s from %s: %v", method, caller.Client, err)
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		caller.Principal = principal
+	}
+	return context.WithValue(ctx, callerKey{}, caller), nil
+}
+
+func (h *Handler) grpcUnaryAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := h.grpcAuthenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (h *Handler) grpcStreamAuth(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := h.grpcAuthenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authedStream is a server stream whose context carries the Caller
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context {
+	return s.ctx
+}
+
+func callerFrom(ctx context.Context) Caller {
+	caller, _ := ctx.Value(callerKey{}).(Caller)
+	return caller
+}
+
+// grpcError converts a response for a command that never ran into a gRPC
+// status. It returns nil for responses that are results.
+func grpcError(resp WebResponse) error {
+	if resp.Status != "error" {
+		return nil
+	}
+	switch {
+	case resp.Code == CodeForbidden || resp.Code == CodeApprovalRequired || resp.Code == CodeApprovalDenied || resp.Code == CodeLimitExceeded:
+		return status.Errorf(codes.PermissionDenied, "%s: %s", resp.Code, resp.Message)
+	case resp.Code == CodeInvalidRequest:
+		return status.Errorf(codes.InvalidArgument, "%s: %s", resp.Code, resp.Message)
+	case resp.Code == CodeRefundRejected || resp.Code == CodeHookRejected:
+		return status.Errorf(codes.FailedPrecondition, "%s: %s", resp.Code, resp.Message)
+	case resp.Code == CodeIdempotencyConflict:
+		return status.Errorf(codes.AlreadyExists, "%s: %s", resp.Code, resp.Message)
+	case resp.Code == CodeShuttingDown:
+		return status.Error(codes.Unavailable, resp.Message)
+	case resp.Message == msgBusy:
+		return status.Error(codes.Aborted, resp.Message)
+	}
+	return nil
+}
+
+func (s *grpcService) ExecuteTransaction(ctx context.Context, in *terminalpb.TransactionRequest) (*terminalpb.TransactionResult, error) {
+	command, ok := grpcCommands[in.Command]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown command %v", in.Command)
+	}
+	req := WebRequest{
+		RequestID:       in.RequestId,
+		Command:         command,
+		Amount:          in.Amount,
+		OrderNo:         in.OrderNo,
+		MerchantOrderID: in.MerchantOrderId,
+		Override:        in.Override,
+		IdempotencyKey:  in.IdempotencyKey,
+	}
+	if in.ApprovalToken != "" {
+		req.Approval = &Approval{Token: in.ApprovalToken}
+	}
+
+	resp := s.h.Execute(callerFrom(ctx), req, nil)
+	if err := grpcError(resp); err != nil {
+		return nil, err
+	}
+	result := &terminalpb.TransactionResult{
+		TransactionId: resp.TransactionID,
+		RequestId:     resp.RequestID,
+		Message:       resp.Message,
+		Replayed:      resp.Replayed,
+	}
+	switch resp.Status {
+	case "success":
+		result.Outcome = terminalpb.Outcome_OUTCOME_APPROVED
+	case "unknown":
+		result.Outcome = terminalpb.Outcome_OUTCOME_UNKNOWN
+	default:
+		result.Outcome = terminalpb.Outcome_OUTCOME_FAILED
+	}
+	result.Response = responseFields(resp.Data)
+	return result, nil
+}
+
+// responseFields returns the parsed terminal response carried by a
+// transaction response, whichever form its data takes
+func responseFields(data interface{}) map[string]string {
+	switch d := data.(type) {
+	case map[string]string:
+		return d
+	case ledger.Record: // Replayed from the ledger
+		return d.Response
+	case driver.UnresolvedTransaction: // Set once a late response resolves it
+		return d.Result
+	}
+	return nil
+}
+
+func (s *grpcService) GetStatus(ctx context.Context, _ *terminalpb.GetStatusRequest) (*terminalpb.TerminalStatus, error) {
+	resp := s.h.Execute(callerFrom(ctx), WebRequest{Command: "STATUS"}, nil)
+	if err := grpcError(resp); err != nil {
+		return nil, err
+	}
+	st, ok := resp.Data.(ServerStatus)
+	if !ok {
+		return nil, status.Error(codes.Internal, resp.Message)
+	}
+	return terminalStatus(st.StatusInfo), nil
+}
+
+func (s *grpcService) Abort(ctx context.Context, _ *terminalpb.AbortRequest) (*terminalpb.ControlResult, error) {
+	return s.control(ctx, "ABORT")
+}
+
+func (s *grpcService) Reconnect(ctx context.Context, _ *terminalpb.ReconnectRequest) (*terminalpb.ControlResult, error) {
+	return s.control(ctx, "RECONNECT")
+}
+
+func (s *grpcService) control(ctx context.Context, command string) (*terminalpb.ControlResult, error) {
+	resp := s.h.Execute(callerFrom(ctx), WebRequest{Command: command}, nil)
+	if err := grpcError(resp); err != nil {
+		return nil, err
+	}
+	return &terminalpb.ControlResult{Ok: resp.Status == "success", Message: resp.Message}, nil
+}
+
+func (s *grpcService) WatchStatus(in *terminalpb.WatchStatusRequest, stream grpc.ServerStreamingServer[terminalpb.TerminalStatus]) error {
+	caller := callerFrom(stream.Context())
+	if resp, ok := s.h.checkTopics(caller, []string{TopicStatus}); !ok {
+		return status.Error(codes.PermissionDenied, resp.Message)
+	}
+
+	es, _ := s.h.events.subscribe(Subscription{Topics: []string{TopicStatus}, TerminalID: in.TerminalId}, nil)
+	defer s.h.events.unsubscribe(es)
+	logger.Info("gRPC status watch opened by %s (%s)", caller.Principal, caller.Client)
+	defer logger.Info("gRPC status watch closed by %s (%s)", caller.Principal, caller.Client)
+
+	if err := stream.Send(terminalStatus(s.h.Manager().GetStatus())); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.h.done:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case ev, ok := <-es.ch:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "status watcher fell behind")
+			}
+			info, ok := ev.resp.Data.(driver.StatusInfo)
+			if !ok {
+				continue
+			}
+			if err := stream.Send(terminalStatus(info)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// terminalStatus converts a driver status to its gRPC message
+func terminalStatus(info driver.StatusInfo) *terminalpb.TerminalStatus {
+	st := &terminalpb.TerminalStatus{
+		State:         info.State,
+		Message:       info.Message,
+		ElapsedMs:     info.ElapsedMs,
+		TimeoutMs:     info.TimeoutMs,
+		LastError:     info.LastError,
+		TransType:     info.TransType,
+		Amount:        info.Amount,
+		Connected:     info.IsConnected,
+		TransactionId: info.TransactionID,
+		RequestId:     info.RequestID,
+	}
+	if !info.StartedAt.IsZero() {
+		st.StartedAt = timestamppb.New(info.StartedAt)
+	}
+	return st
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"ecpay-server/auth"
+	"ecpay-server/ledger"
+	"ecpay-server/terminalpb"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGRPC serves h on an in-memory listener and returns a client
+func startGRPC(t *testing.T, h *Handler) terminalpb.TerminalGatewayClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := h.NewGRPCServer()
+	go srv.Serve(lis)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+	})
+	return terminalpb.NewTerminalGatewayClient(conn)
+}
+
+func newGRPCTestHandler(t *testing.T) (*Handler, terminalpb.TerminalGatewayClient) {
+	h := NewHandler(startMockPOS(t, 200*time.Millisecond), nil)
+	t.Cleanup(h.Close)
+	a, err := auth.New(auth.Config{Tokens: []auth.Token{{Principal: "till", Token: "secret"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetAuthenticator(a)
+	return h, startGRPC(t, h)
+}
+
+func withToken(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+}
+
+func TestGRPCTransaction(t *testing.T) {
+	_, client := newGRPCTestHandler(t)
+	ctx, cancel := context.WithTimeout(withToken(context.Background()), 10*time.Second)
+	defer cancel()
+
+	st, err := client.GetStatus(ctx, &terminalpb.GetStatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != "IDLE" || !st.Connected {
+		t.Fatalf("GetStatus = %s connected=%v, want IDLE and connected", st.State, st.Connected)
+	}
+
+	watch, err := client.WatchStatus(ctx, &terminalpb.WatchStatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := watch.Recv(); err != nil {
+		t.Fatalf("initial status: %v", err)
+	}
+
+	results := make(chan *terminalpb.TransactionResult, 1)
+	go func() {
+		res, err := client.ExecuteTransaction(ctx, &terminalpb.TransactionRequest{
+			RequestId: "r1",
+			Command:   terminalpb.Command_COMMAND_SALE,
+			Amount:    "100",
+		})
+		if err != nil {
+			t.Errorf("ExecuteTransaction: %v", err)
+		}
+		results <- res
+	}()
+
+	// The watch sees the transaction while it runs
+	for {
+		st, err := watch.Recv()
+		if err != nil {
+			t.Fatalf("WatchStatus: %v", err)
+		}
+		if st.State == "WAIT_RESPONSE" {
+			if st.RequestId != "r1" || st.TransactionId == "" || st.Amount != "100.00" {
+				t.Fatalf("status during SALE = %+v", st)
+			}
+			break
+		}
+	}
+
+	res := <-results
+	if res == nil {
+		t.FailNow()
+	}
+	if res.Outcome != terminalpb.Outcome_OUTCOME_APPROVED || res.RequestId != "r1" || res.TransactionId == "" {
+		t.Fatalf("result = %+v, want approved r1", res)
+	}
+	if res.Response["ApprovalNo"] != "123456" || res.Response["Amount"] != "100.00" {
+		t.Fatalf("response fields = %v", res.Response)
+	}
+}
+
+func TestGRPCReplayFromLedger(t *testing.T) {
+	led, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer led.Close()
+	led.Put(ledger.Record{ID: "20260116095100-aaaaaaaa", IdemKey: "k1", Command: "SALE", Amount: "100.00",
+		Status: ledger.StatusApproved, StartedAt: time.Now(),
+		Response: map[string]string{"ApprovalNo": "123456", "Amount": "100.00"}})
+
+	h := newTestHandler(t, led)
+	a, err := auth.New(auth.Config{Tokens: []auth.Token{{Principal: "till", Token: "secret"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetAuthenticator(a)
+	client := startGRPC(t, h)
+
+	ctx, cancel := context.WithTimeout(withToken(context.Background()), 10*time.Second)
+	defer cancel()
+	res, err := client.ExecuteTransaction(ctx, &terminalpb.TransactionRequest{
+		Command:        terminalpb.Command_COMMAND_SALE,
+		Amount:         "100",
+		IdempotencyKey: "k1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Replayed || res.Outcome != terminalpb.Outcome_OUTCOME_APPROVED || res.TransactionId != "20260116095100-aaaaaaaa" {
+		t.Fatalf("result = %+v, want the approved sale replayed", res)
+	}
+	if res.Response["ApprovalNo"] != "123456" {
+		t.Fatalf("response fields = %v, want the stored terminal response", res.Response)
+	}
+}
+
+func TestGRPCUnauthenticated(t *testing.T) {
+	_, client := newGRPCTestHandler(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.GetStatus(ctx, &terminalpb.GetStatusRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("GetStatus without token: got %v, want Unauthenticated", err)
+	}
+	bad := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer wrong")
+	if _, err := client.GetStatus(bad, &terminalpb.GetStatusRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("GetStatus with a wrong token: got %v, want Unauthenticated", err)
+	}
+}
+
+func TestGRPCRejections(t *testing.T) {
+	_, client := newGRPCTestHandler(t)
+	ctx, cancel := context.WithTimeout(withToken(context.Background()), 10*time.Second)
+	defer cancel()
+
+	sale := func(amount, key string) error {
+		_, err := client.ExecuteTransaction(ctx, &terminalpb.TransactionRequest{
+			Command:        terminalpb.Command_COMMAND_SALE,
+			Amount:         amount,
+			IdempotencyKey: key,
+		})
+		return err
+	}
+
+	if err := sale("abc", ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("malformed amount: got %v, want InvalidArgument", err)
+	}
+
+	// No ledger: refunds need an override
+	_, err := client.ExecuteTransaction(ctx, &terminalpb.TransactionRequest{
+		Command: terminalpb.Command_COMMAND_REFUND,
+		Amount:  "50",
+		OrderNo: "EC0001",
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("refund without a sale: got %v, want FailedPrecondition", err)
+	}
+
+	if err := sale("100", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sale("200", "k1"); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("idempotency key reused: got %v, want AlreadyExists", err)
+	}
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	for code, want := range map[string]codes.Code{
+		CodeForbidden:           codes.PermissionDenied,
+		CodeLimitExceeded:       codes.PermissionDenied,
+		CodeInvalidRequest:      codes.InvalidArgument,
+		CodeRefundRejected:      codes.FailedPrecondition,
+		CodeHookRejected:        codes.FailedPrecondition,
+		CodeIdempotencyConflict: codes.AlreadyExists,
+		CodeShuttingDown:        codes.Unavailable,
+	} {
+		if got := status.Code(grpcError(WebResponse{Status: "error", Code: code})); got != want {
+			t.Errorf("grpcError(%s) = %v, want %v", code, got, want)
+		}
+	}
+	if err := grpcError(WebResponse{Status: "error", Message: "transaction declined: 0001"}); err != nil {
+		t.Errorf("grpcError(declined) = %v, want a result", err)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"ecpay-server/certs"
+	"ecpay-server/driver"
+	"net/http"
+	"time"
+)
+
+// SetCertificates reports the served TLS certificate in STATUS
+func (h *Handler) SetCertificates(m *certs.Manager) {
+	h.certs = m
+}
+
+// startedAt is when the process came up, reported by /healthz
+var startedAt = time.Now()
+
+// HealthStatus is the body of /healthz
+type HealthStatus struct {
+	Status        string    `json:"status"` // "ok"
+	Version       string    `json:"version"`
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+}
+
+// ReadyStatus is the body of /readyz
+type ReadyStatus struct {
+	Ready        bool                  `json:"ready"`
+	POSConnected bool                  `json:"pos_connected"`
+	Scanner      *driver.ScannerStatus `json:"scanner,omitempty"`
+	Transaction  string                `json:"transaction_state"`
+	Unresolved   int                   `json:"unresolved"`
+	ShuttingDown bool                  `json:"shutting_down,omitempty"`
+}
+
+// ServeHealth handles /healthz: the process is up and serving requests
+func (h *Handler) ServeHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthStatus{
+		Status:        "ok",
+		Version:       ServerVersion,
+		StartedAt:     startedAt,
+		UptimeSeconds: int64(time.Since(startedAt).Seconds()),
+	})
+}
+
+// ServeReady handles /readyz: a POS terminal is connected and transactions
+// can be sent. Returns 503 while the terminal is missing or the server is
+// shutting down.
+func (h *Handler) ServeReady(w http.ResponseWriter, r *http.Request) {
+	status := ReadyStatus{
+		POSConnected: h.Manager().IsConnected(),
+		Transaction:  h.Manager().GetStatus().State,
+		Unresolved:   len(h.Manager().UnresolvedTransactions()),
+		ShuttingDown: h.isDraining(),
+	}
+	if h.Manager().Scanner != nil {
+		scanner := h.Manager().Scanner.Status()
+		status.Scanner = &scanner
+	}
+	status.Ready = status.POSConnected && !status.ShuttingDown
+
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
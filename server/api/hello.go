@@ -0,0 +1,192 @@
+package api
+
+import (
+	"ecpay-server/auth"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Protocol versions of the WebSocket/REST message format. Version 1 is the
+// original format; clients that do not declare a version are served it, so
+// older webapp and Electron builds keep working when newer versions are
+// added.
+const (
+	ProtocolVersion       = 1 // Newest version this server speaks
+	MinProtocolVersion    = 1 // Oldest version this server still speaks
+	LegacyProtocolVersion = 1 // Version of clients that do not declare one
+)
+
+// ServerVersion is the server build version, set at build time with
+// -ldflags "-X ecpay-server/api.ServerVersion=1.2.3"
+var ServerVersion = "dev"
+
+// CodeUnsupportedVersion rejects a client whose protocol version is too old
+const CodeUnsupportedVersion = "UNSUPPORTED_PROTOCOL_VERSION"
+
+// Commands lists every command the server accepts, in the order reported by
+// hello
+var Commands = []string{
+	"SALE", "REFUND", "VOID", "SETTLEMENT", "ECHO",
+	"STATUS", "ABORT", "RECONNECT", "UNRESOLVED", "RESOLVE", "HISTORY", "RESTART", "RESTART_PROCESS",
+	"HELLO",
+	"SUBSCRIBE", "UNSUBSCRIBE",
+}
+
+// TransactionType is a transaction command and its protocol TransType code
+type TransactionType struct {
+	Command   string `json:"command"`
+	TransType string `json:"trans_type"`
+}
+
+var transactionTypes = []TransactionType{
+	{"SALE", "01"},
+	{"REFUND", "02"},
+	{"VOID", "60"},
+	{"SETTLEMENT", "50"},
+	{"ECHO", "80"},
+}
+
+// Hello is sent to every WebSocket client on connect and in reply to HELLO
+type Hello struct {
+	ProtocolVersion    int               `json:"protocol_version"` // Version used for this client
+	MinProtocolVersion int               `json:"min_protocol_version"`
+	MaxProtocolVersion int               `json:"max_protocol_version"`
+	ServerVersion      string            `json:"server_version"`
+	Principal          string            `json:"principal"`
+	Commands           []string          `json:"commands"`          // Commands this client may send
+	TransactionTypes   []TransactionType `json:"transaction_types"` // Transaction commands this client may run
+	Capabilities       []string          `json:"capabilities"`      // Optional server features that are enabled
+	Topics             []string          `json:"topics"`            // Topics this client may subscribe to
+	Terminal           TerminalInfo      `json:"terminal"`
+}
+
+// TerminalInfo identifies the POS terminal. TerminalID and MerchantID come
+// from the most recent terminal response and are empty until one was
+// received.
+type TerminalInfo struct {
+	Connected  bool   `json:"connected"`
+	Port       string `json:"port,omitempty"`
+	TerminalID string `json:"terminal_id,omitempty"`
+	MerchantID string `json:"merchant_id,omitempty"`
+}
+
+// negotiateVersion picks the protocol version for a client that speaks up to
+// requested (0: undeclared)
+func negotiateVersion(requested int) (int, error) {
+	if requested == 0 {
+		return LegacyProtocolVersion, nil
+	}
+	if requested < MinProtocolVersion {
+		return 0, fmt.Errorf("protocol version %d is no longer supported (supported: %d-%d)",
+			requested, MinProtocolVersion, ProtocolVersion)
+	}
+	return min(requested, ProtocolVersion), nil
+}
+
+// versionParam reads the protocol_version query parameter
+func versionParam(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("protocol_version")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid protocol_version: %s", v)
+	}
+	return n, nil
+}
+
+// hello answers a HELLO from a client speaking up to requested
+func (h *Handler) hello(caller Caller, requested int) WebResponse {
+	version, err := negotiateVersion(requested)
+	if err != nil {
+		return WebResponse{Status: "error", Message: err.Error(), CommandType: "hello", Code: CodeUnsupportedVersion}
+	}
+	return WebResponse{
+		Status:      "hello",
+		Message:     "ECPay POS Server " + ServerVersion,
+		CommandType: "hello",
+		Data:        h.helloData(caller.Principal, version),
+	}
+}
+
+func (h *Handler) helloData(principal auth.Principal, version int) Hello {
+	hello := Hello{
+		ProtocolVersion:    version,
+		MinProtocolVersion: MinProtocolVersion,
+		MaxProtocolVersion: ProtocolVersion,
+		ServerVersion:      ServerVersion,
+		Principal:          principal.Name,
+		Commands:           []string{},
+		TransactionTypes:   []TransactionType{},
+		Topics:             []string{},
+		Capabilities:       h.capabilities(),
+		Terminal:           h.terminalInfo(),
+	}
+	for _, cmd := range Commands {
+		if h.policy.Load().Allowed(principal, cmd) {
+			hello.Commands = append(hello.Commands, cmd)
+		}
+	}
+	for _, t := range transactionTypes {
+		if h.policy.Load().Allowed(principal, t.Command) {
+			hello.TransactionTypes = append(hello.TransactionTypes, t)
+		}
+	}
+	for _, t := range Topics {
+		if h.policy.Load().TopicAllowed(principal, t) {
+			hello.Topics = append(hello.Topics, t)
+		}
+	}
+	return hello
+}
+
+// capabilities lists the optional features enabled on this server
+func (h *Handler) capabilities() []string {
+	caps := []string{"idempotency", "reconciliation", "rest"}
+	if h.Ledger != nil {
+		caps = append(caps, "history")
+	}
+	if h.auth.Load().Enabled() {
+		caps = append(caps, "auth")
+	}
+	if policy := h.policy.Load(); policy != nil {
+		caps = append(caps, "roles")
+		if policy.RefundApprovalThreshold > 0 {
+			caps = append(caps, "refund_approval")
+		}
+	}
+	if h.webhooks.Load() != nil {
+		caps = append(caps, "webhooks")
+	}
+	if h.certs != nil {
+		caps = append(caps, "tls")
+	}
+	return caps
+}
+
+// terminalInfo reports the connected terminal and its identity
+func (h *Handler) terminalInfo() TerminalInfo {
+	info := TerminalInfo{Connected: h.Manager().IsConnected()}
+	if info.Connected && h.Manager().Scanner != nil {
+		info.Port = h.Manager().Scanner.Status().LastPort
+	}
+	info.TerminalID, info.MerchantID = h.terminal.get()
+	return info
+}
+
+// serveHello handles GET /api/v1/hello
+func (h *Handler) serveHello(w http.ResponseWriter, r *http.Request) {
+	caller, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	version, err := versionParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, WebResponse{Status: "error", Message: err.Error(), CommandType: "hello"})
+		return
+	}
+	resp := h.Execute(caller, WebRequest{Command: "HELLO", ProtocolVersion: version}, nil)
+	writeJSON(w, httpStatusFor(resp), resp)
+}
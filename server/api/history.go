@@ -0,0 +1,219 @@
+package api
+
+import (
+	"ecpay-server/driver"
+	"ecpay-server/ledger"
+	"ecpay-server/logger"
+	"ecpay-server/metrics"
+	"ecpay-server/protocol"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryQuery holds the HISTORY filter parameters. Dates accept
+// YYYY-MM-DD (whole day, local time) or RFC3339.
+type HistoryQuery struct {
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	Type      string `json:"type,omitempty"`   // "SALE" or TransType code "01"
+	Status    string `json:"status,omitempty"` // "APPROVED", "DECLINED", "FAILED", "UNKNOWN", "PENDING"
+	OrderNo   string `json:"order_no,omitempty"`
+	MinAmount string `json:"min_amount,omitempty"`
+	MaxAmount string `json:"max_amount,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+}
+
+// toFilter converts the query into a ledger filter
+func (q HistoryQuery) toFilter() (ledger.Filter, error) {
+	f := ledger.Filter{
+		Type:    q.Type,
+		Status:  q.Status,
+		OrderNo: q.OrderNo,
+		Limit:   q.Limit,
+	}
+
+	var err error
+	if f.From, err = parseQueryTime(q.From, false); err != nil {
+		return f, fmt.Errorf("invalid from: %v", err)
+	}
+	if f.To, err = parseQueryTime(q.To, true); err != nil {
+		return f, fmt.Errorf("invalid to: %v", err)
+	}
+	if q.MinAmount != "" {
+		if f.MinAmount, err = protocol.ParseAmount(q.MinAmount); err != nil {
+			return f, fmt.Errorf("invalid min_amount: %v", err)
+		}
+	}
+	if q.MaxAmount != "" {
+		if f.MaxAmount, err = protocol.ParseAmount(q.MaxAmount); err != nil {
+			return f, fmt.Errorf("invalid max_amount: %v", err)
+		}
+	}
+	return f, nil
+}
+
+// parseQueryTime parses a date or timestamp; a bare date used as an upper
+// bound covers the whole day
+func parseQueryTime(s string, endOfDay bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		if endOfDay {
+			return t.Add(24*time.Hour - time.Nanosecond), nil
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// queryHistory runs a history query against the ledger
+func (h *Handler) queryHistory(q HistoryQuery) ([]ledger.Record, error) {
+	if h.Ledger == nil {
+		return nil, errors.New("transaction ledger not available")
+	}
+	f, err := q.toFilter()
+	if err != nil {
+		return nil, err
+	}
+	return h.Ledger.Query(f)
+}
+
+// ServeHistory handles GET /api/v1/history
+func (h *Handler) ServeHistory(w http.ResponseWriter, r *http.Request) {
+	caller, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if resp, ok := h.authorize(caller, "HISTORY"); !ok {
+		resp.CommandType = "history"
+		writeJSON(w, httpStatusFor(resp), resp)
+		return
+	}
+	params := r.URL.Query()
+	q := HistoryQuery{
+		From:      params.Get("from"),
+		To:        params.Get("to"),
+		Type:      params.Get("type"),
+		Status:    params.Get("status"),
+		OrderNo:   params.Get("order_no"),
+		MinAmount: params.Get("min_amount"),
+		MaxAmount: params.Get("max_amount"),
+	}
+	if limit := params.Get("limit"); limit != "" {
+		q.Limit, _ = strconv.Atoi(limit)
+	}
+
+	records, err := h.queryHistory(q)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, WebResponse{Status: "error", Message: err.Error(), CommandType: "history"})
+		return
+	}
+	writeJSON(w, http.StatusOK, WebResponse{
+		Status:      "success",
+		Message:     fmt.Sprintf("%d transactions", len(records)),
+		CommandType: "history",
+		Data:        records,
+	})
+}
+
+// recordStart writes a PENDING ledger record before the transaction runs
+func (h *Handler) recordStart(tx logger.Txn, caller Caller, req WebRequest, transType, approvedBy string, tags map[string]string) {
+	if h.Ledger == nil {
+		return
+	}
+	err := h.Ledger.Put(ledger.Record{
+		ID:         tx.ID,
+		RequestID:  tx.RequestID,
+		IdemKey:    req.IdempotencyKey,
+		Command:    req.Command,
+		TransType:  transType,
+		Amount:     req.Amount,
+		OrderNo:    req.OrderNo,
+		MerchantID: req.MerchantOrderID,
+		Status:     ledger.StatusPending,
+		Client:     caller.Client,
+		Principal:  caller.Principal.Name,
+		ApprovedBy: approvedBy,
+		Tags:       tags,
+		StartedAt:  time.Now(),
+	})
+	if err != nil {
+		tx.Error("Ledger write failed: %v", err)
+		return
+	}
+	h.pushLedger(tx.ID)
+}
+
+// transactionStatus maps a transaction result to its ledger status
+func transactionStatus(result map[string]string, err error) string {
+	if err == nil {
+		return ledger.StatusApproved
+	}
+	var unknown *driver.UnknownOutcomeError
+	switch {
+	case errors.As(err, &unknown):
+		return ledger.StatusUnknown
+	case result != nil && result["RespCode"] != "" && result["RespCode"] != "0000":
+		return ledger.StatusDeclined
+	default:
+		return ledger.StatusFailed
+	}
+}
+
+// recordResult stores the outcome of a finished transaction
+func (h *Handler) recordResult(tx logger.Txn, command string, result map[string]string, err error) {
+	status := transactionStatus(result, err)
+	metrics.Transactions.WithLabelValues(command, strings.ToLower(status)).Inc()
+	h.terminal.note(result)
+
+	if h.Ledger == nil {
+		return
+	}
+
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	if err := h.Ledger.Finish(tx.ID, status, errMsg, result); err != nil {
+		tx.Error("Ledger write failed: %v", err)
+		return
+	}
+	h.pushLedger(tx.ID)
+}
+
+// recordDriverEvent stores outcomes the driver reports outside a request
+func (h *Handler) recordDriverEvent(e driver.Event) {
+	if e, ok := e.(driver.TransactionReconciled); ok {
+		h.recordReconciliation(e.Transaction)
+	}
+}
+
+// recordReconciliation stores the real outcome of an UNKNOWN transaction
+func (h *Handler) recordReconciliation(tx driver.UnresolvedTransaction) {
+	h.terminal.note(tx.Result)
+	if h.Ledger == nil {
+		return
+	}
+
+	status := ledger.StatusDeclined
+	if tx.Outcome == driver.OutcomeApproved {
+		status = ledger.StatusApproved
+	}
+	reason := "reconciled from late response"
+	if tx.ResolvedBy != "" {
+		reason = "resolved by " + tx.ResolvedBy
+		if tx.Note != "" {
+			reason += ": " + tx.Note
+		}
+	}
+	if err := h.Ledger.Finish(tx.ID, status, reason, tx.Result); err != nil {
+		logger.Txn{ID: tx.ID, RequestID: tx.RequestID}.Error("Ledger write failed: %v", err)
+		return
+	}
+	h.pushLedger(tx.ID)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"ecpay-server/hooks"
+	"ecpay-server/logger"
+	"ecpay-server/protocol"
+	"fmt"
+	"maps"
+)
+
+// CodeHookRejected is the error code of transactions vetoed by a before
+// hook, or refused because a hook failed
+const CodeHookRejected = "HOOK_REJECTED"
+
+// Hooks returns the transaction hook registry. Hooks registered here in
+// code stay across RESTART; the hooks of the config file are replaced.
+func (h *Handler) Hooks() *hooks.Registry {
+	return h.hooks
+}
+
+// runBeforeHooks lets the before hooks veto or adjust a transaction. On
+// success ecpayReq and req carry any changes to the amount or order number.
+// The transaction type cannot be changed.
+func (h *Handler) runBeforeHooks(tx logger.Txn, ht *hooks.Transaction, req *WebRequest, ecpayReq *protocol.ECPayRequest) *WebResponse {
+	if err := h.hooks.RunBefore(context.Background(), ht); err != nil {
+		tx.Warn("%s rejected: %v", req.Command, err)
+		return &WebResponse{Status: "error", Message: err.Error(), Code: CodeHookRejected}
+	}
+	ht.Request.TransType, ht.Request.HostID = ecpayReq.TransType, ecpayReq.HostID
+
+	if ht.Request.Amount != ecpayReq.Amount {
+		// Commands sent without an amount must stay without one
+		if req.Amount == "" || ht.Request.Amount <= 0 {
+			msg := fmt.Sprintf("transaction hook set an invalid amount %s for %s", ht.Request.Amount, req.Command)
+			tx.Warn("%s rejected: %s", req.Command, msg)
+			return &WebResponse{Status: "error", Message: msg, Code: CodeHookRejected}
+		}
+		tx.Info("Amount changed by transaction hook: %s -> %s", ecpayReq.Amount, ht.Request.Amount)
+		req.Amount = ht.Request.Amount.String()
+	}
+	if ht.Request.OrderNo != ecpayReq.OrderNo {
+		tx.Info("Order number changed by transaction hook: %q -> %q", ecpayReq.OrderNo, ht.Request.OrderNo)
+		req.OrderNo = ht.Request.OrderNo
+	}
+	*ecpayReq = ht.Request
+	return nil
+}
+
+// runAfterHooks passes a finished transaction to the after hooks. They run
+// on their own goroutine, so the terminal is free for the next transaction
+// while they do; Shutdown waits for them. Only call it between enter and
+// leave.
+func (h *Handler) runAfterHooks(ht hooks.Transaction, event TransactionEvent) {
+	ht.Tags = maps.Clone(ht.Tags) // event.Tags is shared with other listeners
+	res := hooks.Result{
+		Status:   event.Status,
+		Error:    event.Error,
+		Response: maps.Clone(event.Response),
+	}
+	h.inflight.Add(1)
+	go func() {
+		defer h.inflight.Done()
+		h.hooks.RunAfter(context.Background(), ht, res)
+	}()
+}
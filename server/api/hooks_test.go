@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"ecpay-server/auth"
+	"ecpay-server/hooks"
+	"ecpay-server/ledger"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHookCannotRaiseRefundPastRemaining(t *testing.T) {
+	led, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer led.Close()
+	sale := ledger.Record{
+		ID:        "sale-1",
+		Command:   "SALE",
+		TransType: "01",
+		Amount:    "100.00",
+		ECOrderNo: "EC0001",
+		Status:    ledger.StatusApproved,
+		StartedAt: time.Now(),
+	}
+	if err := led.Put(sale); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newTestHandler(t, led)
+	h.Hooks().Before(hooks.Options{Name: "raise"}, hooks.BeforeFunc(func(_ context.Context, t *hooks.Transaction) error {
+		t.Request.Amount = 15000
+		return nil
+	}))
+
+	caller := Caller{Principal: auth.Principal{Name: "till"}}
+	resp := h.Execute(caller, WebRequest{Command: "REFUND", Amount: "50", OrderNo: "EC0001"}, nil)
+	if resp.Status != "error" || !strings.Contains(resp.Message, "exceeds refundable") {
+		t.Fatalf("REFUND raised by hook: got %s %q, want refund rejected", resp.Status, resp.Message)
+	}
+	check, ok := resp.Data.(RefundCheck)
+	if !ok || check.Requested != 15000 || check.Remaining != 10000 {
+		t.Fatalf("rejection data = %+v, want requested 150.00 of remaining 100.00", resp.Data)
+	}
+	if refunds, _ := led.RefundsFor("EC0001"); len(refunds) != 0 {
+		t.Fatalf("rejected refund was recorded: %+v", refunds)
+	}
+}
+
+func TestAfterHooksRunWithoutTerminalLock(t *testing.T) {
+	h := newTestHandler(t, nil)
+	called := make(chan struct{})
+	release := make(chan struct{})
+	h.Hooks().After(hooks.Options{Name: "slow"}, hooks.AfterFunc(func(context.Context, hooks.Transaction, hooks.Result) error {
+		close(called)
+		<-release
+		return nil
+	}))
+
+	done := make(chan WebResponse, 1)
+	go func() { done <- h.Execute(Caller{}, WebRequest{Command: "ECHO"}, nil) }()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("transaction waited for its after hook")
+	}
+	<-called
+	if !h.mu.TryLock() {
+		t.Fatal("terminal still locked while the after hook runs")
+	}
+	h.mu.Unlock()
+
+	// Shutdown waits for the hook
+	stopped := make(chan struct{})
+	go func() {
+		h.Shutdown(context.Background(), "test")
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Shutdown returned while an after hook was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"ecpay-server/logger"
+	"ecpay-server/metrics"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is how long a single write to a client may take
+	writeWait = 10 * time.Second
+
+	// pongWait is how long a client may stay silent before it is considered
+	// dead. Pings are sent often enough that a live client always answers.
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+
+	// clientQueueSize bounds the outbound messages waiting for one client
+	clientQueueSize = 64
+
+	// maxMessageSize bounds a single request from a client
+	maxMessageSize = 64 * 1024
+)
+
+// Eviction reasons for metrics.WebSocketEvictions
+const (
+	evictSlow        = "slow_client"
+	evictPingTimeout = "ping_timeout"
+	evictWriteError  = "write_error"
+)
+
+// client is one WebSocket connection. gorilla/websocket allows a single
+// concurrent writer, so everything sent to the client goes through queue
+// and is written by writePump alone.
+type client struct {
+	conn    *websocket.Conn
+	caller  Caller
+	version int // Negotiated protocol version; only the read loop changes it
+	sub     subscription
+	queue   chan WebResponse
+
+	// Log stream of the logs topic, kept apart from queue so a burst of log
+	// records never gets the client evicted. The read loop owns logSub and
+	// logFilter.
+	logs      chan WebResponse
+	logSub    *logger.Subscription
+	logFilter LogFilter
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeMsg  []byte // Close frame sent by writePump when done is closed
+	flush     bool   // Write queued messages before the close frame
+}
+
+func newClient(conn *websocket.Conn, caller Caller) *client {
+	return &client{
+		conn:   conn,
+		caller: caller,
+		queue:  make(chan WebResponse, clientQueueSize),
+		logs:   make(chan WebResponse, clientQueueSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// enqueue queues a message without blocking. A client whose queue is full is
+// not keeping up and is disconnected rather than holding up the server.
+func (c *client) enqueue(resp WebResponse) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.queue <- resp:
+		return true
+	default:
+		c.evict(evictSlow, websocket.ClosePolicyViolation, "client too slow")
+		return false
+	}
+}
+
+// offer queues a message that a later one supersedes, such as a status
+// update. It is dropped instead when the queue is half full, so periodic
+// updates alone never get a slow client evicted.
+func (c *client) offer(resp WebResponse) {
+	if len(c.queue) >= clientQueueSize/2 {
+		metrics.WebSocketDropped.Inc()
+		return
+	}
+	c.enqueue(resp)
+}
+
+// evict disconnects the client and records why
+func (c *client) evict(reason string, code int, text string) {
+	logger.Warn("Evicting WebSocket client %s (%s): %s", c.caller.Principal, c.caller.Client, reason)
+	metrics.WebSocketEvictions.WithLabelValues(reason).Inc()
+	c.close(code, text)
+}
+
+// close stops the writer, which sends a close frame and closes the connection
+func (c *client) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeMsg = websocket.FormatCloseMessage(code, text)
+		close(c.done)
+	})
+}
+
+// shutdown closes the client after the messages already queued, such as the
+// result of the last transaction, have been written
+func (c *client) shutdown(text string) {
+	c.closeOnce.Do(func() {
+		c.closeMsg = websocket.FormatCloseMessage(websocket.CloseGoingAway, text)
+		c.flush = true
+		close(c.done)
+	})
+}
+
+// writePump writes queued messages and keepalive pings until the client is
+// closed or a write fails
+func (c *client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case resp := <-c.queue:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteJSON(resp); err != nil {
+				c.writeFailed(err)
+				return
+			}
+		case resp := <-c.logs:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteJSON(resp); err != nil {
+				c.writeFailed(err)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.writeFailed(err)
+				return
+			}
+		case <-c.done:
+			if c.flush {
+				c.flushQueue()
+			}
+			c.conn.WriteControl(websocket.CloseMessage, c.closeMsg, time.Now().Add(writeWait))
+			return
+		}
+	}
+}
+
+// flushQueue writes the messages left in the queue, giving up on the first
+// error
+func (c *client) flushQueue() {
+	for {
+		select {
+		case resp := <-c.queue:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteJSON(resp); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (c *client) writeFailed(err error) {
+	select {
+	case <-c.done:
+		// Already closing; the read loop ended first
+		return
+	default:
+	}
+	logger.Warn("WebSocket write to %s failed: %v", c.caller.Client, err)
+	metrics.WebSocketEvictions.WithLabelValues(evictWriteError).Inc()
+	c.close(websocket.CloseGoingAway, "")
+}
+
+// readDeadlines keeps the connection alive while pongs arrive
+func (c *client) readDeadlines() {
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+}
+
+// readFailed closes the client after the read loop ends, recording clients
+// that stopped answering pings
+func (c *client) readFailed(err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		c.evict(evictPingTimeout, websocket.CloseGoingAway, "ping timeout")
+		return
+	}
+	c.close(websocket.CloseNormalClosure, "")
+}
+
+// hub tracks connected clients for broadcasting. Broadcasts only queue
+// messages, so callers (including state callbacks holding driver locks)
+// never wait on network I/O.
+type hub struct {
+	mu      sync.RWMutex
+	clients map[*client]struct{}
+}
+
+func newHub() *hub {
+	return &hub{clients: make(map[*client]struct{})}
+}
+
+// add registers a client
+func (hb *hub) add(c *client) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	hb.clients[c] = struct{}{}
+	metrics.WebSocketClients.Set(float64(len(hb.clients)))
+}
+
+// remove unregisters a client
+func (hb *hub) remove(c *client) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	delete(hb.clients, c)
+	metrics.WebSocketClients.Set(float64(len(hb.clients)))
+}
+
+// publish queues an event for every client subscribed to topic
+func (hb *hub) publish(topic, terminalID string, resp WebResponse) {
+	hb.mu.RLock()
+	defer hb.mu.RUnlock()
+	for c := range hb.clients {
+		if c.sub.wants(topic, terminalID) {
+			c.enqueue(resp)
+		}
+	}
+}
+
+// publishOwner queues an event on topic for the clients of principal that
+// publish leaves out because they are not subscribed to it
+func (hb *hub) publishOwner(topic, terminalID, principal string, resp WebResponse) {
+	hb.mu.RLock()
+	defer hb.mu.RUnlock()
+	for c := range hb.clients {
+		if c.caller.Principal.Name == principal && !c.sub.wants(topic, terminalID) {
+			c.enqueue(resp)
+		}
+	}
+}
+
+// publishStatus queues a status update, which the next one supersedes, for
+// every client subscribed to topic
+func (hb *hub) publishStatus(topic, terminalID string, resp WebResponse) {
+	hb.mu.RLock()
+	defer hb.mu.RUnlock()
+	for c := range hb.clients {
+		if c.sub.wants(topic, terminalID) {
+			c.offer(resp)
+		}
+	}
+}
+
+// broadcast queues a message for every client, whatever it subscribed to
+func (hb *hub) broadcast(resp WebResponse) {
+	hb.mu.RLock()
+	defer hb.mu.RUnlock()
+	for c := range hb.clients {
+		c.enqueue(resp)
+	}
+}
+
+// shutdown closes every client once its queued messages are written
+func (hb *hub) shutdown(text string) {
+	hb.mu.RLock()
+	defer hb.mu.RUnlock()
+	for c := range hb.clients {
+		c.shutdown(text)
+	}
+}
+
+// hasSubscribers reports whether any client is subscribed to topic
+func (hb *hub) hasSubscribers(topic string) bool {
+	hb.mu.RLock()
+	defer hb.mu.RUnlock()
+	for c := range hb.clients {
+		if c.sub.wants(topic, "") {
+			return true
+		}
+	}
+	return false
+}
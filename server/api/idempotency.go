@@ -0,0 +1,214 @@
+package api
+
+import (
+	"ecpay-server/ledger"
+	"ecpay-server/logger"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyWindow is how long idempotency keys are remembered
+// unless configured otherwise
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// CodeIdempotencyConflict is the error code of requests reusing an
+// idempotency key of a different request
+const CodeIdempotencyConflict = "IDEMPOTENCY_CONFLICT"
+
+// errIdempotencyConflict is returned when a key is reused for a different request
+var errIdempotencyConflict = errors.New("idempotency key was already used for a different request")
+
+// idemEntry tracks the transaction submitted under one idempotency key
+type idemEntry struct {
+	txnID       string
+	command     string
+	fingerprint string // Empty for entries loaded from the ledger
+	startedAt   time.Time
+
+	done     chan struct{} // Closed when resp is final
+	resp     WebResponse
+	released bool // The request never reached the terminal; the key is free again
+}
+
+// idempotencyStore maps idempotency keys to their transactions so that a
+// client retry never charges the card twice
+type idempotencyStore struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]*idemEntry
+}
+
+func newIdempotencyStore(window time.Duration) *idempotencyStore {
+	return &idempotencyStore{window: window, entries: make(map[string]*idemEntry)}
+}
+
+// claim registers key for a new transaction. If the key is already known,
+// the existing entry is returned with claimed=false. load is consulted for
+// keys not in memory, e.g. after a restart.
+func (s *idempotencyStore) claim(key string, entry *idemEntry, load func() *idemEntry) (*idemEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, e := range s.entries {
+		if e.finished() && now.Sub(e.startedAt) > s.window {
+			delete(s.entries, k)
+		}
+	}
+
+	if existing, ok := s.entries[key]; ok {
+		return existing, false
+	}
+	if existing := load(); existing != nil && now.Sub(existing.startedAt) <= s.window {
+		s.entries[key] = existing
+		return existing, false
+	}
+
+	entry.done = make(chan struct{})
+	s.entries[key] = entry
+	return entry, true
+}
+
+// complete stores the final response for key. If the request never reached
+// the terminal the key is released so that a retry runs again.
+func (s *idempotencyStore) complete(key string, resp WebResponse, sent bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || entry.finished() {
+		return
+	}
+	entry.resp = resp
+	if !sent {
+		entry.released = true
+		delete(s.entries, key)
+	}
+	close(entry.done)
+}
+
+func (s *idempotencyStore) setWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window = window
+}
+
+func (e *idemEntry) finished() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetIdempotencyWindow sets how long idempotency keys are remembered
+func (h *Handler) SetIdempotencyWindow(window time.Duration) {
+	h.idempotency.setWindow(window)
+}
+
+// requestFingerprint identifies what a transaction request asks for, so a
+// key reused for a different request can be rejected
+func requestFingerprint(req WebRequest) string {
+	return req.Command + "|" + req.Amount + "|" + req.OrderNo + "|" + req.MerchantOrderID
+}
+
+// claimIdempotencyKey claims req's idempotency key for txnID. It returns the
+// entry of an earlier transaction with the same key, or nil if the request
+// should run.
+func (h *Handler) claimIdempotencyKey(txnID string, req WebRequest) (*idemEntry, error) {
+	if req.IdempotencyKey == "" {
+		return nil, nil
+	}
+
+	entry := &idemEntry{
+		txnID:       txnID,
+		command:     req.Command,
+		fingerprint: requestFingerprint(req),
+		startedAt:   time.Now(),
+	}
+	existing, claimed := h.idempotency.claim(req.IdempotencyKey, entry, func() *idemEntry {
+		return h.loadIdempotencyKey(req.IdempotencyKey)
+	})
+	if claimed {
+		return nil, nil
+	}
+
+	// Ledger entries only record the resolved request, so compare the command
+	if existing.command != req.Command || (existing.fingerprint != "" && existing.fingerprint != entry.fingerprint) {
+		logger.Txn{ID: existing.txnID, RequestID: req.RequestID}.Warn("Idempotency key %s reused for a different request", req.IdempotencyKey)
+		return nil, errIdempotencyConflict
+	}
+	logger.Txn{ID: existing.txnID, RequestID: req.RequestID}.Info("Duplicate request with idempotency key %s", req.IdempotencyKey)
+	return existing, nil
+}
+
+// loadIdempotencyKey rebuilds a finished entry from the ledger
+func (h *Handler) loadIdempotencyKey(key string) *idemEntry {
+	if h.Ledger == nil {
+		return nil
+	}
+	rec, found, err := h.Ledger.GetByIdempotencyKey(key)
+	if err != nil {
+		logger.Error("Ledger lookup failed: %v", err)
+		return nil
+	}
+	if !found {
+		return nil
+	}
+
+	entry := &idemEntry{
+		txnID:     rec.ID,
+		command:   rec.Command,
+		startedAt: rec.StartedAt,
+		done:      make(chan struct{}),
+		resp:      recordResponse(rec),
+	}
+	close(entry.done)
+	return entry
+}
+
+// completeIdempotencyKey stores the result of a transaction under its key
+func (h *Handler) completeIdempotencyKey(txnID string, req WebRequest, resp WebResponse, sent bool) {
+	if req.IdempotencyKey == "" {
+		return
+	}
+	h.idempotency.complete(req.IdempotencyKey, resp, sent)
+	if !sent && h.Ledger != nil {
+		if err := h.Ledger.ReleaseIdempotencyKey(req.IdempotencyKey, txnID); err != nil {
+			logger.Txn{ID: txnID, RequestID: req.RequestID}.Error("Ledger write failed: %v", err)
+		}
+	}
+}
+
+// replay returns the stored result of an earlier transaction for a retry
+func (h *Handler) replay(entry *idemEntry, req WebRequest) WebResponse {
+	resp := entry.resp
+	// A late response may have resolved an unknown outcome since
+	if resp.Status == "unknown" && h.Ledger != nil {
+		if rec, found, _ := h.Ledger.Get(entry.txnID); found && rec.Status != ledger.StatusUnknown && rec.Status != ledger.StatusPending {
+			resp = recordResponse(rec)
+		}
+	}
+	resp.RequestID = req.RequestID
+	resp.Replayed = true
+	return resp
+}
+
+// recordResponse builds a transaction response from a ledger record
+func recordResponse(rec ledger.Record) WebResponse {
+	resp := WebResponse{CommandType: "transaction", Data: rec, TransactionID: rec.ID}
+	switch rec.Status {
+	case ledger.StatusApproved:
+		resp.Status = "success"
+		resp.Message = "Transaction Approved"
+	case ledger.StatusUnknown, ledger.StatusPending:
+		resp.Status = "unknown"
+		resp.Message = "transaction outcome unknown"
+	default:
+		resp.Status = "error"
+		resp.Message = rec.Error
+	}
+	return resp
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"ecpay-server/auth"
+	"ecpay-server/logger"
+	"ecpay-server/protocol"
+	"fmt"
+	"time"
+)
+
+// CodeLimitExceeded is the error code of transactions over an amount limit
+// without an approval overriding it
+const CodeLimitExceeded = "LIMIT_EXCEEDED"
+
+// Limit scopes and kinds reported in LimitCheck
+const (
+	LimitScopeTerminal = "terminal" // All transactions on the terminal
+	LimitScopeOperator = "operator" // The caller's own transactions
+	LimitKindSingle    = "single"   // Largest single transaction
+	LimitKindDaily     = "daily"    // Total per calendar day
+)
+
+// LimitCheck describes the limit a transaction exceeded. It is the Data of
+// LIMIT_EXCEEDED rejections.
+type LimitCheck struct {
+	Scope     string         `json:"scope"`
+	Kind      string         `json:"kind"`
+	Command   string         `json:"command"`
+	Limit     protocol.Money `json:"limit"`
+	UsedToday protocol.Money `json:"used_today,omitempty"` // Daily limits: total before this transaction
+	Amount    protocol.Money `json:"amount"`
+	Reason    string         `json:"reason"`
+}
+
+// checkLimits enforces the policy's amount limits on a SALE, REFUND or VOID.
+// Exceeding one needs an approval credential from an approver other than
+// the caller; the approver's name is returned.
+func (h *Handler) checkLimits(tx logger.Txn, caller Caller, req WebRequest, amount protocol.Money) (string, *WebResponse) {
+	exceeded, err := h.exceededLimit(caller, req.Command, amount)
+	if exceeded == nil {
+		return "", nil
+	}
+	if err != nil {
+		// Daily totals could not be read: treat the limit as exceeded
+		tx.Error("Limit check failed: %v", err)
+	}
+
+	deny := func(code, msg string) (string, *WebResponse) {
+		logger.Audit("DENIED command=%s txn=%s principal=%s client=%s amount=%s code=%s: %s",
+			req.Command, tx.ID, caller.Principal, caller.Client, amount, code, msg)
+		return "", &WebResponse{Status: "error", Message: msg, Code: code, Data: exceeded}
+	}
+
+	if req.Approval == nil || req.Approval.Token == "" {
+		return deny(CodeLimitExceeded, exceeded.Reason+"; supervisor approval required")
+	}
+	approver, err := h.verifyApproval(caller, req.Approval)
+	if err != nil {
+		return deny(CodeApprovalDenied, err.Error())
+	}
+
+	logger.Audit("LIMIT OVERRIDE command=%s txn=%s principal=%s approver=%s amount=%s: %s",
+		req.Command, tx.ID, caller.Principal, approver, amount, exceeded.Reason)
+	return approver.Name, nil
+}
+
+// exceededLimit returns the first terminal or operator limit that amount
+// would exceed, or nil. If the ledger cannot be read for a daily limit the
+// limit counts as exceeded and the error is returned as well.
+func (h *Handler) exceededLimit(caller Caller, command string, amount protocol.Money) (*LimitCheck, error) {
+	policy := h.policy.Load()
+	terminalID, _ := h.terminal.get()
+
+	type scoped struct {
+		scope     string
+		principal string // Whose transactions count toward the daily total
+		limit     auth.Limit
+	}
+	var limits []scoped
+	if l, ok := policy.TerminalLimit(terminalID, command); ok {
+		limits = append(limits, scoped{LimitScopeTerminal, "", l})
+	}
+	if l, ok := policy.OperatorLimit(caller.Principal, command); ok {
+		limits = append(limits, scoped{LimitScopeOperator, caller.Principal.Name, l})
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, s := range limits {
+		check := &LimitCheck{Scope: s.scope, Command: command, Amount: amount}
+
+		if max := protocol.Money(s.limit.MaxAmount); max > 0 && amount > max {
+			check.Kind, check.Limit = LimitKindSingle, max
+			check.Reason = fmt.Sprintf("%s of %s exceeds the %s limit of %s per transaction", command, amount, s.scope, max)
+			return check, nil
+		}
+
+		daily := protocol.Money(s.limit.DailyTotal)
+		if daily <= 0 {
+			continue
+		}
+		check.Kind, check.Limit = LimitKindDaily, daily
+		if h.Ledger == nil {
+			check.Reason = fmt.Sprintf("%s daily %s limit cannot be checked: transaction history unavailable", s.scope, command)
+			return check, fmt.Errorf("ledger not available")
+		}
+		used, err := h.Ledger.Total(today, command, s.principal)
+		if err != nil {
+			check.Reason = fmt.Sprintf("%s daily %s limit cannot be checked: %v", s.scope, command, err)
+			return check, err
+		}
+		if used+amount > daily {
+			check.UsedToday = used
+			check.Reason = fmt.Sprintf("%s of %s exceeds the %s daily %s limit of %s (%s used today)",
+				command, amount, s.scope, command, daily, used)
+			return check, nil
+		}
+	}
+	return nil, nil
+}
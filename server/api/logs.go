@@ -0,0 +1,232 @@
+package api
+
+import (
+	"ecpay-server/logger"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLogBacklog is how many recent records are replayed on subscribe
+const defaultLogBacklog = 50
+
+// LogFilter selects log records for the logs topic and GET /api/v1/logs
+type LogFilter struct {
+	Level         string `json:"level,omitempty"`          // Minimum level: "DEBUG", "INFO", "WARN" (with AUDIT), "ERROR"
+	TransactionID string `json:"transaction_id,omitempty"` // Only records of this transaction
+	Backlog       *int   `json:"backlog,omitempty"`        // Recent records to replay (default 50, max 500)
+}
+
+func (f LogFilter) validate() error {
+	if !logger.ValidLevel(f.Level) {
+		return fmt.Errorf("invalid log level %q", f.Level)
+	}
+	if f.Backlog != nil && (*f.Backlog < 0 || *f.Backlog > logger.BacklogSize) {
+		return fmt.Errorf("backlog must be 0-%d", logger.BacklogSize)
+	}
+	return nil
+}
+
+func (f LogFilter) backlog() int {
+	if f.Backlog == nil {
+		return defaultLogBacklog
+	}
+	return *f.Backlog
+}
+
+func (f LogFilter) loggerFilter() logger.Filter {
+	return logger.Filter{Level: strings.ToUpper(f.Level), TxnID: f.TransactionID}
+}
+
+// logFilterParams reads a log filter from query parameters, each name
+// prefixed with prefix
+func logFilterParams(q url.Values, prefix string) (LogFilter, error) {
+	f := LogFilter{
+		Level:         q.Get(prefix + "level"),
+		TransactionID: q.Get(prefix + "transaction_id"),
+	}
+	if v := q.Get(prefix + "backlog"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid %sbacklog: %s", prefix, v)
+		}
+		f.Backlog = &n
+	}
+	return f, f.validate()
+}
+
+// logResponse wraps a log record as a pushed message
+func logResponse(rec logger.Record) WebResponse {
+	return WebResponse{
+		Status:        "log",
+		Message:       rec.Message,
+		CommandType:   "log",
+		Data:          rec,
+		TransactionID: rec.TxnID,
+		RequestID:     rec.RequestID,
+		Topic:         TopicLogs,
+	}
+}
+
+// syncLogs starts, restarts or stops a client's log stream to match its
+// subscription and log filter. Only the client's read loop calls it.
+func (h *Handler) syncLogs(c *client) {
+	if c.logSub != nil {
+		c.logSub.Unsubscribe()
+		c.logSub = nil
+	}
+	if !c.sub.has(TopicLogs) {
+		return
+	}
+	sub, backlog := logger.Subscribe(c.logFilter.loggerFilter(), c.logFilter.backlog())
+	c.logSub = sub
+	go c.forwardLogs(sub, backlog)
+}
+
+// forwardLogs feeds log records into the client's log queue. It may block on
+// a slow client; the logger then drops records for this subscriber only.
+// It must not log itself, or every record would produce another.
+func (c *client) forwardLogs(sub *logger.Subscription, backlog []logger.Record) {
+	defer sub.Unsubscribe()
+
+	send := func(resp WebResponse) bool {
+		select {
+		case c.logs <- resp:
+			return true
+		case <-c.done:
+			return false
+		}
+	}
+
+	for _, rec := range backlog {
+		if !send(logResponse(rec)) {
+			return
+		}
+	}
+	var reported uint64
+	for rec := range sub.C {
+		if dropped := sub.Dropped(); dropped > reported {
+			notice := WebResponse{
+				Status:      "log",
+				Message:     fmt.Sprintf("%d log records skipped (client too slow)", dropped-reported),
+				CommandType: "log",
+				Topic:       TopicLogs,
+			}
+			if !send(notice) {
+				return
+			}
+			reported = dropped
+		}
+		if !send(logResponse(rec)) {
+			return
+		}
+	}
+}
+
+// logEventID is the Server-Sent Events ID of a log record. Record sequence
+// numbers restart with the server, so like event IDs they carry the run's
+// epoch.
+func (h *Handler) logEventID(seq uint64) string {
+	return eventID{epoch: h.events.epoch, seq: seq}.String()
+}
+
+// serveLogs handles GET /api/v1/logs: a Server-Sent Events stream of log
+// records. Query: level, transaction_id, backlog. A reconnecting client's
+// Last-Event-ID resumes after the last record it saw, as far as the backlog
+// reaches; an ID from before a restart gets a reset and the usual backlog.
+func (h *Handler) serveLogs(w http.ResponseWriter, r *http.Request) {
+	caller, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if resp, ok := h.checkTopics(caller, []string{TopicLogs}); !ok {
+		writeJSON(w, http.StatusForbidden, resp)
+		return
+	}
+	filter, err := logFilterParams(r.URL.Query(), "")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, controlResponse("error", err.Error(), nil))
+		return
+	}
+
+	backlog := filter.backlog()
+	var lastSeq uint64
+	lastID := r.Header.Get("Last-Event-ID")
+	stale := false
+	if lastID != "" {
+		id, err := parseEventID(lastID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, controlResponse("error", "invalid Last-Event-ID: "+lastID, nil))
+			return
+		}
+		if id.epoch == h.events.epoch {
+			lastSeq = id.seq
+			backlog = logger.BacklogSize
+		} else {
+			stale = true
+		}
+	}
+
+	sub, replay := logger.Subscribe(filter.loggerFilter(), backlog)
+	defer sub.Unsubscribe()
+
+	stream, ok := startSSE(w)
+	if !ok {
+		return
+	}
+	logger.Info("Log stream opened by %s (%s) level=%q txn=%q", caller.Principal, caller.Client, filter.Level, filter.TransactionID)
+	defer logger.Info("Log stream closed by %s (%s)", caller.Principal, caller.Client)
+
+	if stale {
+		// The server restarted; its earlier records are gone. The ID lets
+		// the client resume from here next time.
+		var head uint64
+		if len(replay) > 0 {
+			head = replay[len(replay)-1].Seq
+		}
+		reset := controlResponse("error", fmt.Sprintf("server restarted since log record %s", lastID), nil)
+		if err := stream.event(h.logEventID(head), lostReset, reset); err != nil {
+			return
+		}
+	}
+	for _, rec := range replay {
+		if rec.Seq <= lastSeq {
+			continue
+		}
+		if err := stream.event(h.logEventID(rec.Seq), "log", rec); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	var reported uint64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.done:
+			return
+		case <-keepAlive.C:
+			if err := stream.ping(); err != nil {
+				return
+			}
+		case rec, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if dropped := sub.Dropped(); dropped > reported {
+				if err := stream.event("", "dropped", map[string]uint64{"count": dropped - reported}); err != nil {
+					return
+				}
+				reported = dropped
+			}
+			if err := stream.event(h.logEventID(rec.Seq), "log", rec); err != nil {
+				return
+			}
+		}
+	}
+}
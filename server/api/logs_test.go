@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bufio"
+	"ecpay-server/auth"
+	"ecpay-server/logger"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogStreamResume(t *testing.T) {
+	h := newTestHandler(t, nil)
+	a, err := auth.New(auth.Config{Tokens: []auth.Token{{Principal: "lead", Token: "secret", Roles: []string{"supervisor"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetAuthenticator(a)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	logger.Info("log stream test record")
+
+	// first returns the ID and type of the first event after resuming from
+	// lastID
+	first := func(lastID string) (id, event string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+"/api/v1/logs", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Last-Event-ID", lastID)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if v, ok := strings.CutPrefix(line, "id: "); ok {
+				id = v
+			} else if v, ok := strings.CutPrefix(line, "event: "); ok {
+				return id, v
+			}
+		}
+		t.Fatalf("resume from %s: stream ended: %v", lastID, scanner.Err())
+		return "", ""
+	}
+
+	// IDs of an earlier run, or of a version without epochs, get a reset
+	// carrying an ID of this run
+	for _, lastID := range []string{"lrx0a2b3-2", "2"} {
+		id, event := first(lastID)
+		if event != lostReset || !strings.HasPrefix(id, h.events.epoch+"-") {
+			t.Fatalf("resume from %s: event %s id %s, want a reset with an ID of epoch %s", lastID, event, id, h.events.epoch)
+		}
+	}
+
+	// This run's IDs resume with the next record
+	id, event := first(h.logEventID(0))
+	if event != "log" || !strings.HasPrefix(id, h.events.epoch+"-") {
+		t.Fatalf("resume from 0: event %s id %s, want a log record of epoch %s", event, id, h.events.epoch)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/v1/logs", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Last-Event-ID", h.events.epoch+"-x")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid Last-Event-ID: HTTP %d, want 400", resp.StatusCode)
+	}
+}
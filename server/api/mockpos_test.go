@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"ecpay-server/driver"
+	"ecpay-server/ledger"
+	"ecpay-server/protocol"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mockPOS is an in-process terminal answering like the mock-pos tool: it
+// ACKs every valid frame and approves it after delay
+type mockPOS struct {
+	lis    net.Listener
+	delay  time.Duration
+	orders atomic.Int64
+}
+
+// startMockPOS starts a terminal on a local port and returns a manager
+// connected to it
+func startMockPOS(t *testing.T, delay time.Duration) *driver.SerialManager {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := &mockPOS{lis: lis, delay: delay}
+	go pos.serve()
+
+	port, err := driver.OpenSerial("tcp://"+lis.Addr().String(), 115200)
+	if err != nil {
+		lis.Close()
+		t.Fatal(err)
+	}
+	manager := driver.NewSerialManager(port)
+	t.Cleanup(func() {
+		manager.Close()
+		lis.Close()
+	})
+	return manager
+}
+
+// newTestHandler returns a handler driving a mock terminal, closed when the
+// test ends
+func newTestHandler(t *testing.T, led *ledger.Ledger) *Handler {
+	t.Helper()
+	h := NewHandler(startMockPOS(t, 50*time.Millisecond), led)
+	t.Cleanup(h.Close)
+	return h
+}
+
+func (p *mockPOS) serve() {
+	for {
+		conn, err := p.lis.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *mockPOS) handle(conn net.Conn) {
+	defer conn.Close()
+	var buf bytes.Buffer
+	chunk := make([]byte, 1024)
+	for {
+		n, err := conn.Read(chunk)
+		if err != nil {
+			return
+		}
+		buf.Write(chunk[:n])
+		for {
+			// Skip the final ACK and anything else between frames
+			if i := bytes.IndexByte(buf.Bytes(), protocol.STX); i < 0 {
+				buf.Reset()
+			} else {
+				buf.Next(i)
+			}
+			if buf.Len() < 603 {
+				break
+			}
+			frame := buf.Next(603)
+			if !protocol.ValidatePacket(frame) {
+				conn.Write([]byte{protocol.NAK})
+				continue
+			}
+			conn.Write([]byte{protocol.ACK})
+			time.Sleep(p.delay)
+			conn.Write(p.approve(frame))
+		}
+	}
+}
+
+// approve builds an approved response to a request frame
+func (p *mockPOS) approve(frame []byte) []byte {
+	req := frame[1:601]
+	data := bytes.Repeat([]byte{' '}, protocol.PacketLen)
+	copy(data[0:4], req[0:4])     // TransType, HostID
+	copy(data[31:43], req[31:43]) // Amount
+	now := time.Now()
+	copy(data[43:55], now.Format("060102150405"))
+	copy(data[10:29], "4311-****-****-1234")
+	copy(data[55:61], "123456")
+	copy(data[61:65], "0000")
+	copy(data[65:73], "TERM0001")
+	copy(data[73:88], "MER000123456789")
+	copy(data[88:108], fmt.Sprintf("EC%018d", p.orders.Add(1)))
+	copy(data[492:546], req[492:546]) // POS time and request hash
+
+	resp := append([]byte{protocol.STX}, data...)
+	resp = append(resp, protocol.ETX)
+	return append(resp, protocol.CalculateLRC(resp[1:]))
+}
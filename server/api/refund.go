@@ -0,0 +1,178 @@
+package api
+
+import (
+	"ecpay-server/ledger"
+	"ecpay-server/logger"
+	"ecpay-server/protocol"
+	"fmt"
+)
+
+// CodeRefundRejected is the error code of refunds and voids failing the
+// guardrails against the original sale
+const CodeRefundRejected = "REFUND_REJECTED"
+
+// RefundCheck describes how a refund request compares to its original sale.
+// It is returned to the client when a refund is rejected.
+type RefundCheck struct {
+	OriginalSale *ledger.Record `json:"original_sale,omitempty"`
+	Requested    protocol.Money `json:"requested"`
+	Refunded     protocol.Money `json:"refunded"`  // Approved, pending or unknown refunds so far
+	Remaining    protocol.Money `json:"remaining"` // Still refundable
+}
+
+// RefundRejectedError is returned when a refund or void fails the guardrails
+type RefundRejectedError struct {
+	Reason string
+	Check  RefundCheck
+}
+
+func (e *RefundRejectedError) Error() string {
+	return "refund rejected: " + e.Reason
+}
+
+// resolveOriginalOrder fills req.OrderNo with the EC order number of the
+// original sale for REFUND/VOID. The reference may be given as
+// merchant_order_id, or as order_no holding either the EC or the merchant
+// order number.
+func (h *Handler) resolveOriginalOrder(tx logger.Txn, req *WebRequest) error {
+	if h.Ledger == nil {
+		if req.OrderNo == "" {
+			return &RefundRejectedError{Reason: "order_no is required (ledger not available to resolve merchant_order_id)"}
+		}
+		return nil
+	}
+
+	merchantID := req.MerchantOrderID
+	if req.OrderNo != "" {
+		if _, found, err := h.Ledger.GetByECOrderNo(req.OrderNo); err != nil {
+			return fmt.Errorf("ledger lookup failed: %v", err)
+		} else if found {
+			return nil
+		}
+		// Not an EC order number we know: try it as a merchant reference
+		merchantID = req.OrderNo
+	}
+	if merchantID == "" {
+		return &RefundRejectedError{Reason: "order_no or merchant_order_id is required"}
+	}
+
+	sale, found, err := h.Ledger.FindSaleByMerchantID(merchantID)
+	if err != nil {
+		return fmt.Errorf("ledger lookup failed: %v", err)
+	}
+	if !found {
+		if req.OrderNo != "" {
+			// Unknown either way; checkRefund decides whether override applies
+			return nil
+		}
+		return &RefundRejectedError{Reason: fmt.Sprintf("merchant order %s not found", merchantID)}
+	}
+	if sale.ECOrderNo == "" {
+		return &RefundRejectedError{
+			Reason: fmt.Sprintf("sale for merchant order %s has no EC order number (%s)", merchantID, sale.Status),
+			Check:  RefundCheck{OriginalSale: &sale},
+		}
+	}
+
+	tx.Info("Resolved merchant order %s to EC order %s", merchantID, sale.ECOrderNo)
+	req.MerchantOrderID = merchantID
+	req.OrderNo = sale.ECOrderNo
+	return nil
+}
+
+// checkRefund validates a REFUND or VOID against the original sale in the
+// ledger. Refunds for orders the server has no record of are only allowed
+// with an explicit override. A VOID reverses the full sale amount and
+// defaults to it when no amount is given.
+func (h *Handler) checkRefund(tx logger.Txn, req *WebRequest) (*RefundCheck, error) {
+	if err := h.resolveOriginalOrder(tx, req); err != nil {
+		return nil, err
+	}
+
+	var sale ledger.Record
+	var found bool
+	if h.Ledger != nil {
+		var err error
+		if sale, found, err = h.Ledger.GetByECOrderNo(req.OrderNo); err != nil {
+			return nil, fmt.Errorf("ledger lookup failed: %v", err)
+		}
+	}
+	if req.Command == "VOID" && req.Amount == "" && found {
+		req.Amount = sale.Amount
+	}
+
+	requested, err := protocol.ParseAmount(req.Amount)
+	if err != nil || requested <= 0 {
+		return nil, &RefundRejectedError{Reason: fmt.Sprintf("invalid amount %q", req.Amount)}
+	}
+	req.Amount = requested.String()
+
+	check := RefundCheck{Requested: requested}
+	if h.Ledger == nil {
+		if req.Override {
+			tx.Warn("%s override for %s: ledger not available", req.Command, req.OrderNo)
+			return &check, nil
+		}
+		return nil, &RefundRejectedError{Reason: "transaction ledger not available, override required", Check: check}
+	}
+
+	if !found {
+		if req.Override {
+			tx.Warn("%s override for unknown order %s (amount=%s)", req.Command, req.OrderNo, requested)
+			return &check, nil
+		}
+		return nil, &RefundRejectedError{Reason: "original sale not found, override required", Check: check}
+	}
+	check.OriginalSale = &sale
+
+	if sale.Status != ledger.StatusApproved {
+		if req.Override {
+			tx.Warn("%s override for order %s with sale status %s", req.Command, req.OrderNo, sale.Status)
+			return &check, nil
+		}
+		return nil, &RefundRejectedError{
+			Reason: fmt.Sprintf("original sale is %s, override required", sale.Status),
+			Check:  check,
+		}
+	}
+
+	refunds, err := h.Ledger.RefundsFor(req.OrderNo)
+	if err != nil {
+		return nil, fmt.Errorf("ledger lookup failed: %v", err)
+	}
+	for _, r := range refunds {
+		// Count anything that may have reached the card
+		switch r.Status {
+		case ledger.StatusApproved, ledger.StatusPending, ledger.StatusUnknown:
+			amount, _ := protocol.ParseAmount(r.Amount)
+			check.Refunded += amount
+		}
+	}
+
+	saleAmount, _ := protocol.ParseAmount(sale.Amount)
+	check.Remaining = saleAmount - check.Refunded
+	if check.Remaining < 0 {
+		check.Remaining = 0
+	}
+
+	if req.Command == "VOID" {
+		if check.Refunded > 0 {
+			return nil, &RefundRejectedError{Reason: "sale was already refunded or voided", Check: check}
+		}
+		if requested != saleAmount {
+			return nil, &RefundRejectedError{
+				Reason: fmt.Sprintf("void amount %s must equal sale amount %s", requested, saleAmount),
+				Check:  check,
+			}
+		}
+		return &check, nil
+	}
+
+	if requested > check.Remaining {
+		return nil, &RefundRejectedError{
+			Reason: fmt.Sprintf("amount %s exceeds refundable %s", requested, check.Remaining),
+			Check:  check,
+		}
+	}
+	return &check, nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"ecpay-server/driver"
+	"ecpay-server/logger"
+	"encoding/json"
+	"net/http"
+)
+
+// resolve handles RESOLVE: an operator sets the outcome of an UNKNOWN or
+// interrupted transaction after checking the terminal, so it is no longer
+// reported as unresolved. The outcome is journaled, stored in the ledger and
+// pushed like a late response.
+func (h *Handler) resolve(caller Caller, req WebRequest) WebResponse {
+	if req.TransactionID == "" {
+		return WebResponse{Status: "error", Message: "transaction_id is required", CommandType: "reconciliation"}
+	}
+	tx, err := h.Manager().Resolve(req.TransactionID, req.Outcome, caller.Principal.Name, req.Note)
+	if err != nil {
+		return WebResponse{Status: "error", Message: err.Error(), CommandType: "reconciliation", TransactionID: req.TransactionID}
+	}
+	logger.Audit("RESOLVED txn=%s principal=%s client=%s outcome=%s amount=%s: %s",
+		tx.ID, caller.Principal, caller.Client, tx.Outcome, tx.Amount, req.Note)
+
+	// Reply once the ledger has the outcome
+	h.ledgerEvents.Flush()
+	return WebResponse{
+		Status:        "success",
+		Message:       reconciledMessage(tx),
+		CommandType:   "reconciliation",
+		Data:          tx,
+		TransactionID: tx.ID,
+	}
+}
+
+// reconciledMessage describes how an unresolved transaction was resolved
+func reconciledMessage(tx driver.UnresolvedTransaction) string {
+	if tx.ResolvedBy != "" {
+		return "Resolved by " + tx.ResolvedBy + ": " + tx.Outcome
+	}
+	return "Late POS response received: " + tx.Outcome
+}
+
+// serveResolve handles POST /api/v1/unresolved/{id}/resolve with a body of
+// {"outcome": "APPROVED", "note": "..."}
+func (h *Handler) serveResolve(w http.ResponseWriter, r *http.Request) {
+	caller, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Outcome string `json:"outcome"`
+		Note    string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, controlResponse("error", "Invalid JSON", nil))
+		return
+	}
+	resp := h.Execute(caller, WebRequest{Command: "RESOLVE", TransactionID: r.PathValue("id"), Outcome: body.Outcome, Note: body.Note}, nil)
+	writeJSON(w, httpStatusFor(resp), resp)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"ecpay-server/auth"
+	"ecpay-server/journal"
+	"ecpay-server/ledger"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveRecordsOutcome(t *testing.T) {
+	dir := t.TempDir()
+	led, err := ledger.Open(filepath.Join(dir, "ledger.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer led.Close()
+	led.Put(ledger.Record{ID: "t1", Command: "SALE", TransType: "01", Amount: "100.00", Status: ledger.StatusUnknown, StartedAt: time.Now()})
+
+	journalPath := filepath.Join(dir, "journal.jsonl")
+	j, _, err := journal.Open(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Begin(journal.Entry{ID: "t1", TransType: "01", Amount: "100.00"})
+	j.Update("t1", journal.PhaseUnknown, "timeout")
+	j.Close()
+	j, unresolved, err := journal.Open(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(t, led)
+	manager := h.Manager()
+	manager.SetJournal(j, unresolved)
+
+	cashier := Caller{Principal: auth.Principal{Name: "till", Roles: []string{auth.RoleCashier}}}
+	supervisor := Caller{Principal: auth.Principal{Name: "sup", Roles: []string{auth.RoleSupervisor}}}
+	req := WebRequest{Command: "RESOLVE", TransactionID: "t1", Outcome: "APPROVED", Note: "on terminal report"}
+
+	if resp := h.Execute(cashier, req, nil); resp.Code != CodeForbidden {
+		t.Fatalf("cashier RESOLVE = %s %q, want %s", resp.Status, resp.Message, CodeForbidden)
+	}
+	if resp := h.Execute(supervisor, WebRequest{Command: "RESOLVE", TransactionID: "t1", Outcome: "MAYBE"}, nil); resp.Status != "error" {
+		t.Fatalf("RESOLVE with outcome MAYBE = %s, want error", resp.Status)
+	}
+	if resp := h.Execute(supervisor, req, nil); resp.Status != "success" {
+		t.Fatalf("RESOLVE = %s %q, want success", resp.Status, resp.Message)
+	}
+	if resp := h.Execute(supervisor, req, nil); resp.Status != "error" {
+		t.Fatalf("second RESOLVE = %s, want error", resp.Status)
+	}
+
+	rec, _, _ := led.Get("t1")
+	if rec.Status != ledger.StatusApproved || !strings.Contains(rec.Error, "resolved by sup") {
+		t.Fatalf("ledger record %s %q, want APPROVED resolved by sup", rec.Status, rec.Error)
+	}
+	if list := manager.UnresolvedTransactions(); len(list) != 0 {
+		t.Fatalf("still unresolved: %+v", list)
+	}
+
+	// The next start no longer reports it
+	j.Close()
+	j, unresolved, err = journal.Open(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	if len(unresolved) != 0 {
+		t.Fatalf("resolved transaction reported on restart: %+v", unresolved)
+	}
+}
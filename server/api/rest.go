@@ -0,0 +1,140 @@
+package api
+
+import (
+	"ecpay-server/metrics"
+	"encoding/json"
+	"net/http"
+)
+
+// TransactionRequest is the body of POST /api/v1/transactions. It carries the
+// same fields as a WebSocket command; Wait=false returns a transaction ID to
+// poll instead of blocking until the POS answers.
+type TransactionRequest struct {
+	WebRequest
+	Wait *bool `json:"wait,omitempty"` // Default true
+}
+
+// RegisterRoutes registers the WebSocket, REST, health and metrics endpoints on mux
+func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/ws", h.ServeWS)
+
+	mux.HandleFunc("GET /healthz", h.ServeHealth)
+	mux.HandleFunc("GET /health", h.ServeHealth)
+	mux.HandleFunc("GET /readyz", h.ServeReady)
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	mux.HandleFunc("GET /api/v1/hello", h.serveHello)
+	mux.HandleFunc("POST /api/v1/transactions", h.servePostTransaction)
+	mux.HandleFunc("GET /api/v1/transactions/{id}", h.serveGetTransaction)
+	mux.HandleFunc("GET /api/v1/status", h.serveCommand("STATUS"))
+	mux.HandleFunc("POST /api/v1/abort", h.serveCommand("ABORT"))
+	mux.HandleFunc("POST /api/v1/reconnect", h.serveCommand("RECONNECT"))
+	mux.HandleFunc("POST /api/v1/restart", h.serveCommand("RESTART"))
+	mux.HandleFunc("POST /api/v1/restart/process", h.serveCommand("RESTART_PROCESS"))
+	mux.HandleFunc("GET /api/v1/unresolved", h.serveCommand("UNRESOLVED"))
+	mux.HandleFunc("POST /api/v1/unresolved/{id}/resolve", h.serveResolve)
+	mux.HandleFunc("GET /api/v1/history", h.ServeHistory)
+	mux.HandleFunc("GET /api/v1/events", h.serveEvents)
+	mux.HandleFunc("GET /api/v1/logs", h.serveLogs)
+}
+
+// servePostTransaction handles POST /api/v1/transactions
+func (h *Handler) servePostTransaction(w http.ResponseWriter, r *http.Request) {
+	caller, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req TransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, controlResponse("error", "Invalid JSON", nil))
+		return
+	}
+	if !IsTransactionCommand(req.Command) {
+		writeJSON(w, http.StatusBadRequest, controlResponse("error", "Unknown transaction command: "+req.Command, nil))
+		return
+	}
+
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
+
+	if req.Wait != nil && !*req.Wait {
+		resp := h.StartTransaction(caller, req.WebRequest)
+		writeJSON(w, httpStatusFor(resp), resp)
+		return
+	}
+
+	resp := h.Execute(caller, req.WebRequest, nil)
+	writeJSON(w, httpStatusFor(resp), resp)
+}
+
+// serveGetTransaction handles GET /api/v1/transactions/{id}. Recent results
+// come from memory; older ones from the ledger.
+func (h *Handler) serveGetTransaction(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.authenticate(w, r); !ok {
+		return
+	}
+	txnID := r.PathValue("id")
+
+	if resp, ok := h.Result(txnID); ok {
+		writeJSON(w, httpStatusFor(resp), resp)
+		return
+	}
+
+	if h.Ledger != nil {
+		rec, found, err := h.Ledger.Get(txnID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, WebResponse{Status: "error", Message: err.Error(), CommandType: "transaction"})
+			return
+		}
+		if found {
+			resp := recordResponse(rec)
+			writeJSON(w, httpStatusFor(resp), resp)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusNotFound, WebResponse{Status: "error", Message: "transaction not found", CommandType: "transaction"})
+}
+
+// serveCommand adapts a body-less command to an HTTP handler
+func (h *Handler) serveCommand(command string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+		resp := h.Execute(caller, WebRequest{Command: command}, nil)
+		writeJSON(w, httpStatusFor(resp), resp)
+	}
+}
+
+// httpStatusFor maps a command response to an HTTP status code
+func httpStatusFor(resp WebResponse) int {
+	switch resp.Status {
+	case "error":
+		switch resp.Code {
+		case CodeForbidden, CodeApprovalRequired, CodeApprovalDenied, CodeLimitExceeded:
+			return http.StatusForbidden
+		case CodeUnsupportedVersion:
+			return http.StatusBadRequest
+		case CodeShuttingDown:
+			return http.StatusServiceUnavailable
+		}
+		if resp.Message == msgBusy {
+			return http.StatusConflict
+		}
+		return http.StatusUnprocessableEntity
+	case "processing", "unknown":
+		return http.StatusAccepted
+	default:
+		return http.StatusOK
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
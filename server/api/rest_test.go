@@ -0,0 +1,60 @@
+package api
+
+import (
+	"ecpay-server/auth"
+	"ecpay-server/ledger"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetTransactionFromLedger(t *testing.T) {
+	led, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer led.Close()
+	for id, status := range map[string]string{
+		"approved": ledger.StatusApproved,
+		"declined": ledger.StatusDeclined,
+		"failed":   ledger.StatusFailed,
+		"unknown":  ledger.StatusUnknown,
+	} {
+		led.Put(ledger.Record{ID: id, Command: "SALE", Amount: "100.00", Status: status, Error: "RespCode 1001", StartedAt: time.Now()})
+	}
+
+	h := newTestHandler(t, led)
+	a, err := auth.New(auth.Config{Tokens: []auth.Token{{Principal: "till", Token: "secret"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetAuthenticator(a)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	for _, tc := range []struct {
+		id         string
+		status     string
+		httpStatus int
+	}{
+		{"approved", "success", http.StatusOK},
+		{"declined", "error", http.StatusUnprocessableEntity},
+		{"failed", "error", http.StatusUnprocessableEntity},
+		{"unknown", "unknown", http.StatusAccepted},
+		{"missing", "error", http.StatusNotFound},
+	} {
+		r := httptest.NewRequest("GET", "/api/v1/transactions/"+tc.id, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		var resp WebResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != tc.httpStatus || resp.Status != tc.status {
+			t.Errorf("GET %s = HTTP %d %s, want HTTP %d %s", tc.id, w.Code, resp.Status, tc.httpStatus, tc.status)
+		}
+	}
+}
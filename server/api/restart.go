@@ -0,0 +1,69 @@
+package api
+
+import (
+	"ecpay-server/logger"
+)
+
+// RestartProgress is the data of the "restarting" status events sent while
+// RESTART rebuilds the driver
+type RestartProgress struct {
+	Step  string `json:"step"`            // "stopping", "reloading", "starting", "done"
+	Error string `json:"error,omitempty"` // Set if the step failed
+}
+
+// SetReloader sets the function RESTART calls to reload configuration. It
+// should keep the running configuration if the new one is invalid.
+func (h *Handler) SetReloader(reload func() error) {
+	h.reload = reload
+}
+
+// softRestart rebuilds the driver stack in-process: it closes the port and
+// scanner, reloads configuration and starts a new driver that takes over
+// the journal and unresolved transactions. HTTP, WebSocket and gRPC clients
+// stay connected and follow along through status events.
+func (h *Handler) softRestart(caller Caller) WebResponse {
+	if !h.enter() {
+		return shuttingDown("control", WebRequest{})
+	}
+	defer h.leave()
+
+	// Holding the transaction lock keeps transactions out until the new
+	// driver is in place
+	if !h.mu.TryLock() {
+		return controlResponse("error", msgBusy, nil)
+	}
+	defer h.mu.Unlock()
+
+	logger.Info("Soft restart requested by %s (%s)", caller.Principal, caller.Client)
+	h.reportRestart("Stopping POS driver...", RestartProgress{Step: "stopping"})
+	manager := h.Manager().Rebuild()
+
+	var reloadErr error
+	if h.reload != nil {
+		h.reportRestart("Reloading configuration...", RestartProgress{Step: "reloading"})
+		if reloadErr = h.reload(); reloadErr != nil {
+			logger.Error("Configuration reload failed, keeping the current configuration: %v", reloadErr)
+			h.reportRestart("Configuration reload failed: "+reloadErr.Error(), RestartProgress{Step: "reloading", Error: reloadErr.Error()})
+		}
+	}
+
+	h.reportRestart("Starting POS driver...", RestartProgress{Step: "starting"})
+	h.setManager(manager)
+	manager.Start()
+	h.reportRestart("Restart complete, scanning for POS device", RestartProgress{Step: "done"})
+
+	if reloadErr != nil {
+		return controlResponse("error", "Driver restarted, but configuration was not reloaded: "+reloadErr.Error(), nil)
+	}
+	return controlResponse("success", "Driver restarted", nil)
+}
+
+// reportRestart sends a restart progress event to status subscribers
+func (h *Handler) reportRestart(message string, progress RestartProgress) {
+	h.push(TopicStatus, WebResponse{
+		Status:      "restarting",
+		Message:     message,
+		CommandType: "control",
+		Data:        progress,
+	})
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"ecpay-server/logger"
+	"time"
+)
+
+// CodeShuttingDown is the error code of commands refused because the server
+// is shutting down
+const CodeShuttingDown = "SHUTTING_DOWN"
+
+// abortGrace is how long Shutdown waits for an aborted transaction to end
+const abortGrace = 5 * time.Second
+
+// ShutdownRequested returns a channel that receives the reason when a client
+// asks for a process restart (RESTART_PROCESS). The caller is expected to
+// run Shutdown and exit.
+func (h *Handler) ShutdownRequested() <-chan string {
+	return h.shutdownReq
+}
+
+// requestShutdown asks the owner of the handler to shut down, without waiting
+func (h *Handler) requestShutdown(reason string) {
+	select {
+	case h.shutdownReq <- reason:
+	default:
+	}
+}
+
+// enter registers a command that must finish before shutdown. It returns
+// false once the server is draining.
+func (h *Handler) enter() bool {
+	h.lifecycle.RLock()
+	defer h.lifecycle.RUnlock()
+	if h.draining {
+		return false
+	}
+	h.inflight.Add(1)
+	return true
+}
+
+// leave marks a command registered with enter as finished
+func (h *Handler) leave() {
+	h.inflight.Done()
+}
+
+// isDraining reports whether Shutdown has started
+func (h *Handler) isDraining() bool {
+	h.lifecycle.RLock()
+	defer h.lifecycle.RUnlock()
+	return h.draining
+}
+
+// isClosed reports whether Shutdown has closed the client connections
+func (h *Handler) isClosed() bool {
+	select {
+	case <-h.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// shuttingDown is the response to a command refused while draining
+func shuttingDown(commandType string, req WebRequest) WebResponse {
+	return WebResponse{
+		Status:      "error",
+		Message:     "Server is shutting down",
+		Code:        CodeShuttingDown,
+		CommandType: commandType,
+		RequestID:   req.RequestID,
+	}
+}
+
+// Shutdown stops accepting commands, waits for the running transaction to
+// finish, then closes WebSocket, event stream and gRPC watch clients and
+// stops the status broadcast. If ctx ends first the transaction is aborted
+// and ctx.Err() is returned. STATUS, ABORT and other read-only commands keep
+// working while it waits.
+func (h *Handler) Shutdown(ctx context.Context, reason string) error {
+	h.lifecycle.Lock()
+	if h.draining {
+		h.lifecycle.Unlock()
+		return nil
+	}
+	h.draining = true
+	h.lifecycle.Unlock()
+
+	logger.Info("Shutting down (%s): no longer accepting commands", reason)
+	notice := WebResponse{
+		Status:      "shutting_down",
+		Message:     "Server shutting down (" + reason + ")",
+		CommandType: "control",
+		Topic:       TopicStatus,
+	}
+	terminalID, _ := h.terminal.get()
+	h.events.append(TopicStatus, terminalID, notice)
+	h.hub.broadcast(notice)
+
+	idle := make(chan struct{})
+	go func() {
+		h.inflight.Wait()
+		close(idle)
+	}()
+
+	var err error
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		err = ctx.Err()
+		logger.Warn("Shutdown timed out waiting for the running transaction, aborting it")
+		h.Manager().AbortTransaction()
+		select {
+		case <-idle:
+		case <-time.After(abortGrace):
+			logger.Error("Transaction still running after abort, shutting down anyway")
+		}
+	}
+
+	close(h.done)
+	h.hub.shutdown("server shutting down")
+	h.Close()
+	logger.Info("Clients disconnected")
+	return err
+}
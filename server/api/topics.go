@@ -0,0 +1,272 @@
+package api
+
+import (
+	"ecpay-server/driver"
+	"ecpay-server/ledger"
+	"ecpay-server/logger"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// WebSocket subscription topics
+const (
+	TopicStatus       = "status"       // Terminal and transaction state updates
+	TopicTransactions = "transactions" // Transactions started, completed and reconciled
+	TopicScanner      = "scanner"      // Device scan cycles
+	TopicLedger       = "ledger"       // Ledger records written
+	TopicLogs         = "logs"         // Server log records, filtered per client
+)
+
+// Topics lists every topic a client can subscribe to
+var Topics = []string{TopicStatus, TopicTransactions, TopicScanner, TopicLedger, TopicLogs}
+
+// defaultTopics are pushed to clients that never subscribe. Transaction
+// events carry other clients' card and operator data, so they must be asked
+// for.
+var defaultTopics = []string{TopicStatus}
+
+// Subscription is what a client receives pushes for
+type Subscription struct {
+	Topics     []string `json:"topics"`
+	TerminalID string   `json:"terminal_id,omitempty"` // Only events from this terminal
+}
+
+// subscription is a client's current topics, guarded for the read loop
+// (which changes it) and broadcasters (which read it)
+type subscription struct {
+	mu         sync.RWMutex
+	topics     map[string]bool
+	terminalID string
+}
+
+func (s *subscription) set(sub Subscription) {
+	topics := make(map[string]bool)
+	for _, t := range sub.Topics {
+		topics[t] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.topics = topics
+	s.terminalID = sub.TerminalID
+}
+
+func (s *subscription) remove(topics []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(topics) == 0 {
+		s.topics = make(map[string]bool)
+		return
+	}
+	for _, t := range topics {
+		delete(s.topics, t)
+	}
+}
+
+func (s *subscription) get() Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sub := Subscription{Topics: []string{}, TerminalID: s.terminalID}
+	for _, t := range Topics {
+		if s.topics[t] {
+			sub.Topics = append(sub.Topics, t)
+		}
+	}
+	return sub
+}
+
+// has reports whether topic is subscribed
+func (s *subscription) has(topic string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.topics[topic]
+}
+
+// wants reports whether an event on topic from terminalID should be pushed.
+// Events from a terminal whose ID is not known yet pass the filter: the
+// server drives a single terminal.
+func (s *subscription) wants(topic, terminalID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.topics[topic] {
+		return false
+	}
+	return s.terminalID == "" || terminalID == "" || s.terminalID == terminalID
+}
+
+// checkTopics validates topics and the caller's permission to receive them
+func (h *Handler) checkTopics(caller Caller, topics []string) (WebResponse, bool) {
+	for _, t := range topics {
+		known := false
+		for _, name := range Topics {
+			known = known || t == name
+		}
+		if !known {
+			return controlResponse("error", fmt.Sprintf("unknown topic %q (topics: %s)", t, strings.Join(Topics, ", ")), nil), false
+		}
+		if !h.policy.Load().TopicAllowed(caller.Principal, t) {
+			logger.Audit("DENIED topic=%s principal=%s roles=%v client=%s code=%s",
+				t, caller.Principal, caller.Principal.Roles, caller.Client, CodeForbidden)
+			resp := controlResponse("error", "not permitted: topic "+t, nil)
+			resp.Code = CodeForbidden
+			return resp, false
+		}
+	}
+	return WebResponse{}, true
+}
+
+// initialSubscription reads ?topics=a,b&terminal_id=X on connect. Without
+// topics the client gets the default topics it is allowed to receive.
+func (h *Handler) initialSubscription(caller Caller, r *http.Request) (Subscription, WebResponse, bool) {
+	query := r.URL.Query()
+	sub := Subscription{TerminalID: query.Get("terminal_id")}
+	if param := query.Get("topics"); param != "" {
+		sub.Topics = strings.Split(param, ",")
+		resp, ok := h.checkTopics(caller, sub.Topics)
+		return sub, resp, ok
+	}
+	for _, t := range defaultTopics {
+		if h.policy.Load().TopicAllowed(caller.Principal, t) {
+			sub.Topics = append(sub.Topics, t)
+		}
+	}
+	return sub, WebResponse{}, true
+}
+
+// subscribe handles SUBSCRIBE (replace the topic list and terminal filter)
+// and UNSUBSCRIBE (drop the listed topics, or all) on a WebSocket connection
+func (h *Handler) subscribe(c *client, req WebRequest) WebResponse {
+	if resp, ok := h.authorize(c.caller, req.Command); !ok {
+		resp.CommandType = "control"
+		return resp
+	}
+	if req.LogFilter != nil {
+		if err := req.LogFilter.validate(); err != nil {
+			return controlResponse("error", err.Error(), nil)
+		}
+	}
+	hadLogs := c.sub.has(TopicLogs)
+	if req.Command == "UNSUBSCRIBE" {
+		c.sub.remove(req.Topics)
+	} else {
+		if resp, ok := h.checkTopics(c.caller, req.Topics); !ok {
+			return resp
+		}
+		c.sub.set(Subscription{Topics: req.Topics, TerminalID: req.TerminalID})
+	}
+	// Restart the log stream only when it starts, stops or its filter
+	// changes, so an unrelated SUBSCRIBE does not replay the backlog again
+	if req.LogFilter != nil && req.Command == "SUBSCRIBE" {
+		c.logFilter = *req.LogFilter
+		h.syncLogs(c)
+	} else if c.sub.has(TopicLogs) != hadLogs {
+		h.syncLogs(c)
+	}
+
+	sub := c.sub.get()
+	logger.Info("WebSocket client %s (%s) subscribed to [%s] terminal=%q",
+		c.caller.Principal, c.caller.Client, strings.Join(sub.Topics, ", "), sub.TerminalID)
+	return controlResponse("success", "Subscribed to: "+strings.Join(sub.Topics, ", "), sub)
+}
+
+// terminalState caches the identity of the terminal from its responses, so
+// events can be tagged without a ledger lookup
+type terminalState struct {
+	mu         sync.RWMutex
+	terminalID string
+	merchantID string
+}
+
+// note records the terminal identity from a response
+func (t *terminalState) note(result map[string]string) {
+	if result["TerminalID"] == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.terminalID = result["TerminalID"]
+	t.merchantID = result["MerchantID"]
+}
+
+func (t *terminalState) get() (terminalID, merchantID string) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.terminalID, t.merchantID
+}
+
+// loadTerminal seeds the terminal identity from the newest ledger record
+// carrying a terminal response
+func (h *Handler) loadTerminal() {
+	if h.Ledger == nil {
+		return
+	}
+	records, err := h.Ledger.Query(ledger.Filter{Limit: 20})
+	if err != nil {
+		return
+	}
+	for _, rec := range records {
+		if rec.Response["TerminalID"] != "" {
+			h.terminal.note(rec.Response)
+			return
+		}
+	}
+}
+
+// push sends an event on topic to the subscribed WebSocket and event stream
+// clients
+func (h *Handler) push(topic string, resp WebResponse) {
+	terminalID, _ := h.terminal.get()
+	resp.Topic = topic
+	h.events.append(topic, terminalID, resp)
+	if topic == TopicStatus {
+		h.hub.publishStatus(topic, terminalID, resp)
+		return
+	}
+	h.hub.publish(topic, terminalID, resp)
+}
+
+// pushTransaction reports a transaction lifecycle event
+func (h *Handler) pushTransaction(status, message string, event TransactionEvent) {
+	h.push(TopicTransactions, WebResponse{
+		Status:        status,
+		Message:       message,
+		CommandType:   "transaction",
+		Data:          event,
+		TransactionID: event.TransactionID,
+		RequestID:     event.RequestID,
+	})
+}
+
+// pushLedger reports the current ledger record of a transaction
+func (h *Handler) pushLedger(txnID string) {
+	if h.Ledger == nil || !(h.hub.hasSubscribers(TopicLedger) || h.events.hasSubscribers(TopicLedger)) {
+		return
+	}
+	rec, found, err := h.Ledger.Get(txnID)
+	if err != nil || !found {
+		return
+	}
+	h.push(TopicLedger, WebResponse{
+		Status:        "ledger_update",
+		Message:       rec.Command + " " + rec.Status,
+		CommandType:   "history",
+		Data:          rec,
+		TransactionID: rec.ID,
+		RequestID:     rec.RequestID,
+	})
+}
+
+// pushScanner reports a scan cycle starting or ending
+func (h *Handler) pushScanner(status driver.ScannerStatus) {
+	message := "Scanning for POS device..."
+	if status.State != "scanning" {
+		message = "Scan finished: " + status.LastResult
+	}
+	h.push(TopicScanner, WebResponse{
+		Status:      "scanner_update",
+		Message:     message,
+		CommandType: "status",
+		Data:        status,
+	})
+}
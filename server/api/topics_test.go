@@ -0,0 +1,91 @@
+package api
+
+import (
+	"ecpay-server/auth"
+	"ecpay-server/driver"
+	"ecpay-server/ledger"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestInitialSubscriptionDefaults(t *testing.T) {
+	h := newTestHandler(t, nil)
+	cashier := Caller{Principal: auth.Principal{Name: "till", Roles: []string{auth.RoleCashier}}}
+
+	sub, _, ok := h.initialSubscription(cashier, httptest.NewRequest("GET", "/ws", nil))
+	if !ok || !reflect.DeepEqual(sub.Topics, []string{TopicStatus}) {
+		t.Fatalf("default topics = %v (ok %v), want [%s]", sub.Topics, ok, TopicStatus)
+	}
+
+	sub, _, ok = h.initialSubscription(cashier, httptest.NewRequest("GET", "/ws?topics=status,transactions", nil))
+	if !ok || !reflect.DeepEqual(sub.Topics, []string{TopicStatus, TopicTransactions}) {
+		t.Fatalf("requested topics = %v (ok %v), want [status transactions]", sub.Topics, ok)
+	}
+}
+
+func TestReconciliationReachesOwner(t *testing.T) {
+	led, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer led.Close()
+	led.Put(ledger.Record{ID: "t1", Command: "SALE", Amount: "100.00", Status: ledger.StatusUnknown, Principal: "till", StartedAt: time.Now()})
+
+	h := newTestHandler(t, led)
+	a, err := auth.New(auth.Config{Tokens: []auth.Token{{Principal: "till", Token: "till-secret"}, {Principal: "other", Token: "other-secret"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetAuthenticator(a)
+	h.Manager().Reconciler.Add(driver.UnresolvedTransaction{ID: "t1", TransType: "01", SentAt: time.Now()})
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// Both connect with the default topics and have been greeted
+	dial := func(token string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?token="+token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hello WebResponse
+		if err := conn.ReadJSON(&hello); err != nil || hello.Status != "hello" {
+			t.Fatalf("first message %+v, %v; want hello", hello, err)
+		}
+		return conn
+	}
+	till, other := dial("till-secret"), dial("other-secret")
+	defer till.Close()
+	defer other.Close()
+
+	if _, err := h.Manager().Resolve("t1", driver.OutcomeApproved, "sup", ""); err != nil {
+		t.Fatal(err)
+	}
+	// reconciled reports whether conn receives the reconciliation in time
+	reconciled := func(conn *websocket.Conn, wait time.Duration) bool {
+		conn.SetReadDeadline(time.Now().Add(wait))
+		for {
+			var msg WebResponse
+			if err := conn.ReadJSON(&msg); err != nil {
+				return false
+			}
+			if msg.Status == "reconciled" && msg.TransactionID == "t1" {
+				return true
+			}
+		}
+	}
+	if !reconciled(till, 2*time.Second) {
+		t.Fatal("owner of the transaction was not told of its reconciliation")
+	}
+	if reconciled(other, 200*time.Millisecond) {
+		t.Fatal("reconciliation pushed to another principal without the transactions topic")
+	}
+}
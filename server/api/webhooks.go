@@ -0,0 +1,145 @@
+package api
+
+import (
+	"ecpay-server/driver"
+	"ecpay-server/logger"
+	"ecpay-server/webhook"
+)
+
+// TransactionEvent is the data of transaction.completed and
+// settlement.completed webhooks
+type TransactionEvent struct {
+	TransactionID   string            `json:"transaction_id"`
+	RequestID       string            `json:"request_id,omitempty"`
+	Command         string            `json:"command"`
+	Status          string            `json:"status"` // "APPROVED", "DECLINED", "FAILED", "UNKNOWN"
+	Amount          string            `json:"amount,omitempty"`
+	OrderNo         string            `json:"order_no,omitempty"`    // Order number sent in the request
+	ECOrderNo       string            `json:"ec_order_no,omitempty"` // Order number assigned by the POS
+	MerchantOrderID string            `json:"merchant_order_id,omitempty"`
+	Principal       string            `json:"principal,omitempty"`
+	Error           string            `json:"error,omitempty"`
+	Response        map[string]string `json:"response,omitempty"`
+	Reconciled      bool              `json:"reconciled,omitempty"` // Outcome of an UNKNOWN transaction from a late response
+	Tags            map[string]string `json:"tags,omitempty"`       // Added by transaction hooks
+}
+
+// SetWebhooks publishes transaction and terminal events to d
+func (h *Handler) SetWebhooks(d *webhook.Dispatcher) {
+	h.webhooks.Store(d)
+}
+
+// publish queues a webhook event, if webhooks are configured
+func (h *Handler) publish(eventType string, data interface{}) error {
+	d := h.webhooks.Load()
+	if d == nil {
+		return nil
+	}
+	return d.Publish(eventType, data)
+}
+
+// newTransactionEvent describes a finished transaction
+func newTransactionEvent(tx logger.Txn, caller Caller, req WebRequest, result map[string]string, err error) TransactionEvent {
+	event := TransactionEvent{
+		TransactionID:   tx.ID,
+		RequestID:       tx.RequestID,
+		Command:         req.Command,
+		Status:          transactionStatus(result, err),
+		Amount:          req.Amount,
+		OrderNo:         req.OrderNo,
+		MerchantOrderID: req.MerchantOrderID,
+		Principal:       caller.Principal.Name,
+		Response:        result,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if result != nil {
+		event.ECOrderNo = result["OrderNo"]
+	}
+	return event
+}
+
+// publishTransaction reports a finished transaction to webhooks. ECHO only
+// checks the terminal link and is not published.
+func (h *Handler) publishTransaction(tx logger.Txn, event TransactionEvent) {
+	if event.Command == "ECHO" {
+		return
+	}
+	if err := h.publish(eventType(event.Command), event); err != nil {
+		tx.Error("%v", err)
+	}
+}
+
+// publishReconciliation reports the real outcome of an UNKNOWN transaction
+func (h *Handler) publishReconciliation(ut driver.UnresolvedTransaction) {
+	event := TransactionEvent{
+		TransactionID: ut.ID,
+		RequestID:     ut.RequestID,
+		Command:       commandForTransType(ut.TransType),
+		Status:        ut.Outcome,
+		Amount:        ut.Amount,
+		OrderNo:       ut.OrderNo,
+		Response:      ut.Result,
+		Reconciled:    true,
+	}
+	if ut.Result != nil {
+		event.ECOrderNo = ut.Result["OrderNo"]
+	}
+	if h.Ledger != nil {
+		if rec, ok, _ := h.Ledger.Get(ut.ID); ok {
+			event.Command = rec.Command
+			event.MerchantOrderID = rec.MerchantID
+			event.Principal = rec.Principal
+		}
+	}
+	if err := h.publish(eventType(event.Command), event); err != nil {
+		logger.Txn{ID: ut.ID, RequestID: ut.RequestID}.Error("%v", err)
+	}
+}
+
+// publishDriverEvent turns driver events into webhooks
+func (h *Handler) publishDriverEvent(e driver.Event) {
+	switch e := e.(type) {
+	case driver.ConnectionChanged:
+		h.publishConnection(e.Connected)
+	case driver.TransactionReconciled:
+		h.publishReconciliation(e.Transaction)
+	}
+}
+
+// publishConnection reports the terminal being connected or lost
+func (h *Handler) publishConnection(connected bool) {
+	event := webhook.EventTerminalDisconnected
+	if connected {
+		event = webhook.EventTerminalConnected
+	}
+	if err := h.publish(event, webhook.TerminalData{Connected: connected}); err != nil {
+		logger.Error("%v", err)
+	}
+}
+
+// eventType is the webhook event for a finished command
+func eventType(command string) string {
+	if command == "SETTLEMENT" {
+		return webhook.EventSettlementCompleted
+	}
+	return webhook.EventTransactionCompleted
+}
+
+// commandForTransType names a protocol TransType code
+func commandForTransType(transType string) string {
+	switch transType {
+	case "01":
+		return "SALE"
+	case "02":
+		return "REFUND"
+	case "60":
+		return "VOID"
+	case "50":
+		return "SETTLEMENT"
+	case "80":
+		return "ECHO"
+	}
+	return transType
+}
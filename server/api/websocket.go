@@ -1,59 +1,134 @@
 package api
 
 import (
+	"ecpay-server/auth"
+	"ecpay-server/certs"
 	"ecpay-server/driver"
-	"ecpay-server/protocol"
+	"ecpay-server/hooks"
+	"ecpay-server/ledger"
+	"ecpay-server/logger"
+	"ecpay-server/webhook"
 	"encoding/json"
 	"log"
 	"net/http"
-	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
-
 type WebRequest struct {
-	Command string `json:"command"` // "SALE", "REFUND", "STATUS", "ABORT", "RECONNECT"
-	Amount  string `json:"amount"`
-	OrderNo string `json:"order_no"`
+	RequestID       string        `json:"request_id,omitempty"` // Client correlation ID, echoed on related responses
+	Command         string        `json:"command"`              // "SALE", "REFUND", "VOID", "STATUS", "ABORT", "RECONNECT", "UNRESOLVED", "RESOLVE", "HISTORY", "HELLO", "SUBSCRIBE", "UNSUBSCRIBE"
+	Amount          string        `json:"amount"`
+	OrderNo         string        `json:"order_no"`                    // EC order number (or merchant reference for REFUND/VOID)
+	MerchantOrderID string        `json:"merchant_order_id,omitempty"` // POS software's own order reference
+	Override        bool          `json:"override,omitempty"`          // REFUND/VOID: allow orders without a recorded sale
+	IdempotencyKey  string        `json:"idempotency_key,omitempty"`   // Retries with the same key never run twice
+	Approval        *Approval     `json:"approval,omitempty"`          // REFUND/VOID above the threshold: supervisor credential
+	Query           *HistoryQuery `json:"query,omitempty"`             // HISTORY filter
+	ProtocolVersion int           `json:"protocol_version,omitempty"`  // HELLO: newest protocol version the client speaks
+	Topics          []string      `json:"topics,omitempty"`            // SUBSCRIBE/UNSUBSCRIBE
+	TerminalID      string        `json:"terminal_id,omitempty"`       // SUBSCRIBE: only events from this terminal
+	LogFilter       *LogFilter    `json:"log_filter,omitempty"`        // SUBSCRIBE: filter and backlog of the logs topic
+	TransactionID   string        `json:"transaction_id,omitempty"`    // RESOLVE: the unresolved transaction
+	Outcome         string        `json:"outcome,omitempty"`           // RESOLVE: "APPROVED" or "DECLINED"
+	Note            string        `json:"note,omitempty"`              // RESOLVE: why, e.g. "checked terminal report"
 }
 
 type WebResponse struct {
-	Status      string      `json:"status"` // "success", "error", "processing", "status_update"
+	Status      string      `json:"status"` // "success", "error", "unknown", "processing", "status_update", "reconciled", "hello", "log"
 	Message     string      `json:"message"`
-	CommandType string      `json:"command_type"` // "transaction", "control", "status"
+	CommandType string      `json:"command_type"` // "transaction", "control", "status", "reconciliation", "history", "hello", "log"
 	Data        interface{} `json:"data,omitempty"`
+
+	TransactionID string `json:"transaction_id,omitempty"`
+	RequestID     string `json:"request_id,omitempty"`
+	Replayed      bool   `json:"replayed,omitempty"` // Stored result of an earlier request with the same idempotency key
+	Code          string `json:"code,omitempty"`     // Machine-readable error code, e.g. "FORBIDDEN"
+	Topic         string `json:"topic,omitempty"`    // Subscription topic of a pushed event
 }
 
 type Handler struct {
-	Manager *driver.SerialManager
-	mu      sync.Mutex // Ensure one transaction at a time per server instance
+	manager atomic.Pointer[driver.SerialManager] // Replaced by a soft restart
+	Ledger  *ledger.Ledger                       // Transaction history (nil if unavailable)
+	mu      sync.Mutex                           // Ensure one transaction at a time per server instance
 
 	// Connected clients for broadcasting
-	clients   map[*websocket.Conn]bool
-	clientsMu sync.RWMutex
+	hub *hub
+
+	// Numbered recent events for Server-Sent Events clients
+	events *eventLog
+
+	// Driver event listeners feeding status and scanner events to clients,
+	// and outcomes reported outside a request to the ledger
+	clientEvents *driver.Subscriber
+	ledgerEvents *driver.Subscriber
+
+	// Site-specific logic run around transactions
+	hooks *hooks.Registry
+
+	// Recent transaction results for polling
+	results *resultStore
+
+	// Idempotency key -> transaction, to deduplicate client retries
+	idempotency *idempotencyStore
+
+	// Origin allowlist and client credentials
+	auth     atomic.Pointer[auth.Authenticator]
+	policy   atomic.Pointer[auth.Policy] // Command/role policy (nil: no role checks)
+	upgrader websocket.Upgrader
+
+	// TLS certificate, reported in STATUS (nil without TLS)
+	certs *certs.Manager
+
+	// Identity of the terminal, from its latest response
+	terminal terminalState
+
+	// Webhook outbox (nil without webhook targets) and the last terminal
+	webhooks atomic.Pointer[webhook.Dispatcher]
+
+	// Reloads configuration on RESTART (nil: nothing to reload)
+	reload func() error
 
 	// Status broadcast ticker
 	broadcastTicker *time.Ticker
 	stopBroadcast   chan struct{}
+	closeOnce       sync.Once
+
+	// Shutdown: draining refuses new commands, inflight counts the running
+	// ones, done is closed once clients are disconnected
+	lifecycle   sync.RWMutex
+	draining    bool
+	inflight    sync.WaitGroup
+	done        chan struct{}
+	shutdownReq chan string
 }
 
-func NewHandler(manager *driver.SerialManager) *Handler {
+func NewHandler(manager *driver.SerialManager, led *ledger.Ledger) *Handler {
 	h := &Handler{
-		Manager:       manager,
-		clients:       make(map[*websocket.Conn]bool),
+		Ledger:        led,
+		hub:           newHub(),
+		events:        newEventLog(),
+		results:       newResultStore(),
+		idempotency:   newIdempotencyStore(DefaultIdempotencyWindow),
 		stopBroadcast: make(chan struct{}),
+		done:          make(chan struct{}),
+		shutdownReq:   make(chan string, 1),
+		hooks:         hooks.New(),
+	}
+	// Rejects every client until SetAuthenticator
+	noCredentials, _ := auth.New(auth.Config{})
+	h.auth.Store(noCredentials)
+	h.upgrader = websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return h.auth.Load().CheckOrigin(r) },
 	}
 
-	// Set up state change callback
-	manager.SetStateCallback(func(info driver.StatusInfo) {
-		h.broadcastStatus(info)
-	})
+	h.loadTerminal()
+
+	h.setManager(manager)
+	h.listen(manager.Events)
 
 	// Start periodic status broadcast (every 1s during active transactions)
 	h.broadcastTicker = time.NewTicker(1 * time.Second)
@@ -62,12 +137,45 @@ func NewHandler(manager *driver.SerialManager) *Handler {
 	return h
 }
 
+// Manager returns the current driver. A soft restart replaces it.
+func (h *Handler) Manager() *driver.SerialManager {
+	return h.manager.Load()
+}
+
+// setManager makes manager the current driver
+func (h *Handler) setManager(manager *driver.SerialManager) {
+	h.manager.Store(manager)
+}
+
+// listen registers the handler's independent listeners on the driver
+// event bus, which a soft restart keeps
+func (h *Handler) listen(events *driver.Bus) {
+	h.clientEvents = events.Subscribe("clients", h.broadcastDriverEvent)
+	h.ledgerEvents = events.Subscribe("ledger", h.recordDriverEvent)
+	events.Subscribe("webhooks", h.publishDriverEvent)
+}
+
+// broadcastDriverEvent pushes driver events to WebSocket and event stream
+// clients
+func (h *Handler) broadcastDriverEvent(e driver.Event) {
+	switch e := e.(type) {
+	case driver.StateChanged:
+		h.broadcastStatus(e.Status)
+	case driver.ConnectionChanged:
+		h.broadcastStatus(e.Status)
+	case driver.ScanUpdated:
+		h.pushScanner(e.Status)
+	case driver.TransactionReconciled:
+		h.broadcastReconciliation(e.Transaction)
+	}
+}
+
 // periodicBroadcast sends status updates every second during active transactions
 func (h *Handler) periodicBroadcast() {
 	for {
 		select {
 		case <-h.broadcastTicker.C:
-			status := h.Manager.GetStatus()
+			status := h.Manager().GetStatus()
 			if status.State != "IDLE" {
 				h.broadcastStatus(status)
 			}
@@ -78,170 +186,170 @@ func (h *Handler) periodicBroadcast() {
 	}
 }
 
-// broadcastStatus sends status to all connected clients
+// broadcastStatus queues a status update for status subscribers
 func (h *Handler) broadcastStatus(info driver.StatusInfo) {
-	h.clientsMu.RLock()
-	defer h.clientsMu.RUnlock()
+	h.push(TopicStatus, WebResponse{
+		Status:        "status_update",
+		Message:       info.Message,
+		Data:          info,
+		TransactionID: info.TransactionID,
+		RequestID:     info.RequestID,
+	})
+}
 
+// broadcastReconciliation sends the real outcome of a previously UNKNOWN
+// transaction to transaction subscribers, and to the clients of the
+// principal that started it, which were told the outcome was unknown
+func (h *Handler) broadcastReconciliation(tx driver.UnresolvedTransaction) {
 	resp := WebResponse{
-		Status:  "status_update",
-		Message: info.Message,
-		Data:    info,
-	}
+		Status:      "reconciled",
+		Message:     reconciledMessage(tx),
+		CommandType: "reconciliation",
+		Data:        tx,
 
-	for conn := range h.clients {
-		if err := conn.WriteJSON(resp); err != nil {
-			log.Printf("Broadcast error: %v", err)
-		}
+		TransactionID: tx.ID,
+		RequestID:     tx.RequestID,
 	}
-}
+	h.push(TopicTransactions, resp)
 
-// addClient registers a new client
-func (h *Handler) addClient(conn *websocket.Conn) {
-	h.clientsMu.Lock()
-	defer h.clientsMu.Unlock()
-	h.clients[conn] = true
-}
-
-// removeClient unregisters a client
-func (h *Handler) removeClient(conn *websocket.Conn) {
-	h.clientsMu.Lock()
-	defer h.clientsMu.Unlock()
-	delete(h.clients, conn)
+	if h.Ledger == nil {
+		return
+	}
+	rec, found, err := h.Ledger.Get(tx.ID)
+	if err != nil || !found || rec.Principal == "" {
+		return
+	}
+	terminalID, _ := h.terminal.get()
+	resp.Topic = TopicTransactions
+	h.hub.publishOwner(TopicTransactions, terminalID, rec.Principal, resp)
 }
 
 func (h *Handler) ServeWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Reject before upgrading so the client sees a proper HTTP status
+	caller, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	requested, err := versionParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, WebResponse{Status: "error", Message: err.Error(), CommandType: "hello"})
+		return
+	}
+	version, err := negotiateVersion(requested)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, WebResponse{Status: "error", Message: err.Error(), CommandType: "hello", Code: CodeUnsupportedVersion})
+		return
+	}
+	sub, resp, ok := h.initialSubscription(caller, r)
+	if !ok {
+		status := http.StatusBadRequest
+		if resp.Code == CodeForbidden {
+			status = http.StatusForbidden
+		}
+		writeJSON(w, status, resp)
+		return
+	}
+	logFilter, err := logFilterParams(r.URL.Query(), "log_")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, controlResponse("error", err.Error(), nil))
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Upgrade error:", err)
 		return
 	}
+
+	// Register client; its writer goroutine owns all writes to conn
+	c := newClient(conn, caller)
+	c.version = version
+	c.sub.set(sub)
+	c.logFilter = logFilter
+	h.hub.add(c)
+	go c.writePump()
+	if h.isClosed() {
+		c.shutdown("server shutting down")
+	}
+	logger.Info("WebSocket client connected: %s via %s (%s), protocol v%d", caller.Principal, caller.Principal.Method, caller.Client, version)
+
+	var readErr error
 	defer func() {
-		h.removeClient(conn)
-		conn.Close()
+		h.hub.remove(c)
+		if c.logSub != nil {
+			c.logSub.Unsubscribe()
+		}
+		c.readFailed(readErr)
+		logger.Info("WebSocket client disconnected: %s (%s)", caller.Principal, caller.Client)
 	}()
 
-	// Register client
-	h.addClient(conn)
-
-	// Send initial status
-	status := h.Manager.GetStatus()
-	h.sendStatus(conn, status.Message, status)
+	// Introduce the server, then send initial status
+	h.send(c, h.hello(caller, version))
+	status := h.Manager().GetStatus()
+	h.sendStatus(c, status.Message, status)
+	h.syncLogs(c)
 
+	c.readDeadlines()
 	for {
-		_, msg, err := conn.ReadMessage()
-		if err != nil {
+		var msg []byte
+		_, msg, readErr = conn.ReadMessage()
+		if readErr != nil {
 			break
 		}
 
 		var req WebRequest
 		if err := json.Unmarshal(msg, &req); err != nil {
-			h.sendControl(conn, "error", "Invalid JSON", nil)
+			h.sendControl(c, "error", "Invalid JSON", nil)
 			continue
 		}
 
-		// Handle different commands
-		switch req.Command {
-		case "STATUS":
-			status := h.Manager.GetStatus()
-			h.sendStatus(conn, status.Message, status)
-		case "ABORT":
-			if h.Manager.AbortTransaction() {
-				h.sendControl(conn, "success", "Transaction aborted", nil)
-			} else {
-				h.sendControl(conn, "error", "No transaction to abort", nil)
+		// Transactions and reconnects block, so run them off the read loop
+		switch {
+		case req.Command == "HELLO":
+			resp := h.Execute(caller, req, nil)
+			if hello, ok := resp.Data.(Hello); ok {
+				c.version = hello.ProtocolVersion
 			}
-		case "RECONNECT":
-			go func() {
-				h.sendControl(conn, "processing", "Reconnecting to POS...", nil)
-				if err := h.Manager.Reconnect(); err != nil {
-					h.sendControl(conn, "error", err.Error(), nil)
-				} else {
-					h.sendControl(conn, "success", "Reconnected to POS", nil)
-				}
-			}()
-		case "RESTART":
-			h.sendControl(conn, "processing", "Server restarting...", nil)
-			log.Println("RESTART command received - triggering server restart")
-			go func() {
-				// Give time for the message to be sent
-				time.Sleep(500 * time.Millisecond)
-				os.Exit(0) // Exit, expecting process manager to restart
-			}()
-		case "SALE", "REFUND", "SETTLEMENT", "ECHO":
-			go h.handleTransaction(conn, req)
+			h.send(c, resp)
+		case req.Command == "SUBSCRIBE" || req.Command == "UNSUBSCRIBE":
+			resp := h.subscribe(c, req)
+			resp.RequestID = req.RequestID
+			h.send(c, resp)
+		case IsTransactionCommand(req.Command):
+			go h.send(c, h.Execute(caller, req, nil))
+		case req.Command == "RECONNECT":
+			go func(req WebRequest) {
+				h.send(c, h.Execute(caller, req, func(resp WebResponse) { h.send(c, resp) }))
+			}(req)
 		default:
-			h.sendControl(conn, "error", "Unknown Command", nil)
+			h.send(c, h.Execute(caller, req, nil))
 		}
 	}
 }
 
-func (h *Handler) sendJSON(conn *websocket.Conn, status, message, commandType string, data interface{}) {
-	resp := WebResponse{
+// send queues a response for one client
+func (h *Handler) send(c *client, resp WebResponse) {
+	c.enqueue(resp)
+}
+
+func (h *Handler) sendJSON(c *client, status, message, commandType string, data interface{}) {
+	h.send(c, WebResponse{
 		Status:      status,
 		Message:     message,
 		CommandType: commandType,
 		Data:        data,
-	}
-	if err := conn.WriteJSON(resp); err != nil {
-		log.Printf("Send error: %v", err)
-	}
-}
-
-func (h *Handler) sendControl(conn *websocket.Conn, status, message string, data interface{}) {
-	h.sendJSON(conn, status, message, "control", data)
-}
-
-func (h *Handler) sendTransaction(conn *websocket.Conn, status, message string, data interface{}) {
-	h.sendJSON(conn, status, message, "transaction", data)
+	})
 }
 
-func (h *Handler) sendStatus(conn *websocket.Conn, message string, data interface{}) {
-	h.sendJSON(conn, "status_update", message, "status", data)
+func (h *Handler) sendControl(c *client, status, message string, data interface{}) {
+	h.sendJSON(c, status, message, "control", data)
 }
 
-func (h *Handler) handleTransaction(conn *websocket.Conn, req WebRequest) {
-	// Try to lock for transaction
-	if !h.mu.TryLock() {
-		h.sendTransaction(conn, "error", "POS is busy", nil)
-		return
-	}
-	defer h.mu.Unlock()
-
-	// Build Protocol Request
-	var ecpayReq protocol.ECPayRequest
-
-	switch req.Command {
-	case "SALE":
-		ecpayReq.TransType = "01"
-		ecpayReq.HostID = "01"
-		ecpayReq.Amount = req.Amount
-	case "REFUND":
-		ecpayReq.TransType = "02"
-		ecpayReq.HostID = "01"
-		ecpayReq.Amount = req.Amount
-		ecpayReq.OrderNo = req.OrderNo
-	case "SETTLEMENT":
-		ecpayReq.TransType = "50"
-		ecpayReq.HostID = "01"
-		ecpayReq.Amount = "0"
-	case "ECHO":
-		ecpayReq.TransType = "80"
-		ecpayReq.HostID = "01"
-	}
-
-	// Execute transaction
-	result, err := h.Manager.ExecuteTransaction(ecpayReq)
-	if err != nil {
-		h.sendTransaction(conn, "error", err.Error(), result)
-		return
-	}
-
-	// Success
-	h.sendTransaction(conn, "success", "Transaction Approved", result)
+func (h *Handler) sendStatus(c *client, message string, data interface{}) {
+	h.sendJSON(c, "status_update", message, "status", data)
 }
 
-// Close stops the handler
+// Close stops the handler. Shutdown calls it; calling it again is a no-op.
 func (h *Handler) Close() {
-	close(h.stopBroadcast)
+	h.closeOnce.Do(func() { close(h.stopBroadcast) })
 }
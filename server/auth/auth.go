@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSkew is how far the timestamp of HMAC-signed connect parameters
+// may be from server time
+const DefaultMaxSkew = 60 * time.Second
+
+// DefaultAllowedOrigins are the browser origins accepted when none are
+// configured: the development webapp. The Electron app connects from its
+// main process, which sends no Origin header.
+var DefaultAllowedOrigins = []string{
+	"http://localhost:5173",
+	"http://127.0.0.1:5173",
+}
+
+var (
+	ErrUnauthorized    = errors.New("authentication required")
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrInvalidSig      = errors.New("invalid signature")
+	ErrExpired         = errors.New("signature timestamp out of range")
+	ErrReplayed        = errors.New("signature nonce already used")
+	ErrOriginForbidden = errors.New("origin not allowed")
+)
+
+// Config is the "auth" section of the server config file
+type Config struct {
+	AllowedOrigins []string `json:"allowed_origins,omitempty"` // "*" allows any origin
+	Tokens         []Token  `json:"tokens,omitempty"`
+	HMACKeys       []Key    `json:"hmac_keys,omitempty"`
+	MaxSkew        string   `json:"max_skew,omitempty"` // Go duration, default 60s
+
+	// Insecure accepts every client as an anonymous principal when no
+	// credentials are configured (-insecure-no-auth). Otherwise such an
+	// authenticator rejects everyone.
+	Insecure bool `json:"-"`
+}
+
+// Token is a pre-shared bearer token
+type Token struct {
+	Principal string   `json:"principal"`
+	Token     string   `json:"token"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+// Key is a shared secret for HMAC-signed connect parameters
+type Key struct {
+	Principal string   `json:"principal"`
+	Secret    string   `json:"secret"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+// Principal identifies an authenticated client
+type Principal struct {
+	Name   string   `json:"name"`
+	Method string   `json:"method"` // "token", "hmac" or "none" when authentication is disabled
+	Roles  []string `json:"roles,omitempty"`
+}
+
+func (p Principal) String() string {
+	return p.Name
+}
+
+// HasAnyRole reports whether the principal holds one of roles
+func (p Principal) HasAnyRole(roles []string) bool {
+	for _, want := range roles {
+		for _, have := range p.Roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authenticator checks request origins and credentials
+type Authenticator struct {
+	origins   map[string]bool
+	anyOrigin bool
+	tokens    []Token
+	keys      map[string]Key
+	maxSkew   time.Duration
+	nonces    map[string]time.Time
+	noncesMu  sync.Mutex
+	insecure  bool
+}
+
+// New creates an authenticator from the config
+func New(cfg Config) (*Authenticator, error) {
+	a := &Authenticator{
+		origins:  make(map[string]bool),
+		keys:     make(map[string]Key),
+		maxSkew:  DefaultMaxSkew,
+		nonces:   make(map[string]time.Time),
+		insecure: cfg.Insecure,
+	}
+
+	origins := cfg.AllowedOrigins
+	if len(origins) == 0 {
+		origins = DefaultAllowedOrigins
+	}
+	for _, o := range origins {
+		if o == "*" {
+			a.anyOrigin = true
+		}
+		a.origins[normalizeOrigin(o)] = true
+	}
+
+	for _, t := range cfg.Tokens {
+		if t.Principal == "" || t.Token == "" {
+			return nil, fmt.Errorf("token entry needs principal and token")
+		}
+		a.tokens = append(a.tokens, t)
+	}
+	for _, k := range cfg.HMACKeys {
+		if k.Principal == "" || k.Secret == "" {
+			return nil, fmt.Errorf("hmac key entry needs principal and secret")
+		}
+		a.keys[k.Principal] = k
+	}
+
+	if cfg.MaxSkew != "" {
+		d, err := time.ParseDuration(cfg.MaxSkew)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_skew: %v", err)
+		}
+		a.maxSkew = d
+	}
+	return a, nil
+}
+
+// Enabled reports whether any credentials are configured. Without them
+// every request is rejected, or accepted as an anonymous principal if the
+// authenticator was created with Insecure.
+func (a *Authenticator) Enabled() bool {
+	return len(a.tokens) > 0 || len(a.keys) > 0
+}
+
+// AllowsAnonymous reports whether clients are accepted without credentials
+func (a *Authenticator) AllowsAnonymous() bool {
+	return !a.Enabled() && a.insecure
+}
+
+// CheckOrigin accepts requests without an Origin header (non-browser
+// clients, which still need credentials) and browser requests from an
+// allowed origin
+func (a *Authenticator) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || a.anyOrigin {
+		return true
+	}
+	return a.origins[normalizeOrigin(origin)]
+}
+
+// Authenticate identifies the principal of a request. Credentials are
+// accepted as:
+//
+//	Authorization: Bearer <token>   or   ?token=<token>
+//	?principal=<name>&ts=<unix>&nonce=<random>&sig=<hex HMAC-SHA256>
+//
+// where sig signs "<principal>\n<ts>\n<nonce>" with the principal's secret.
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	if !a.CheckOrigin(r) {
+		return Principal{}, ErrOriginForbidden
+	}
+	if a.AllowsAnonymous() {
+		return Principal{Name: "anonymous", Method: "none"}, nil
+	}
+
+	query := r.URL.Query()
+	if token := bearerToken(r); token != "" {
+		return a.AuthenticateToken(token)
+	}
+	if token := query.Get("token"); token != "" {
+		return a.AuthenticateToken(token)
+	}
+	if query.Get("sig") != "" {
+		return a.checkSignature(query.Get("principal"), query.Get("ts"), query.Get("nonce"), query.Get("sig"))
+	}
+	return Principal{}, ErrUnauthorized
+}
+
+// AuthenticateToken identifies the principal holding a pre-shared token
+func (a *Authenticator) AuthenticateToken(token string) (Principal, error) {
+	// Compare against every token so timing does not reveal which matched
+	var match *Token
+	for i := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.tokens[i].Token)) == 1 {
+			match = &a.tokens[i]
+		}
+	}
+	if match == nil {
+		return Principal{}, ErrInvalidToken
+	}
+	return Principal{Name: match.Principal, Method: "token", Roles: match.Roles}, nil
+}
+
+func (a *Authenticator) checkSignature(principal, ts, nonce, sig string) (Principal, error) {
+	key, ok := a.keys[principal]
+	if !ok || ts == "" || nonce == "" {
+		return Principal{}, ErrInvalidSig
+	}
+
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, Sign([]byte(key.Secret), principal, ts, nonce)) {
+		return Principal{}, ErrInvalidSig
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return Principal{}, ErrInvalidSig
+	}
+	skew := time.Since(time.Unix(unix, 0))
+	if skew > a.maxSkew || skew < -a.maxSkew {
+		return Principal{}, ErrExpired
+	}
+
+	if !a.useNonce(principal + "/" + nonce) {
+		return Principal{}, ErrReplayed
+	}
+	return Principal{Name: principal, Method: "hmac", Roles: key.Roles}, nil
+}
+
+// useNonce records a nonce and reports whether it was unused. Nonces are
+// kept for twice the skew window, after which the timestamp check rejects
+// the signature anyway.
+func (a *Authenticator) useNonce(nonce string) bool {
+	a.noncesMu.Lock()
+	defer a.noncesMu.Unlock()
+
+	now := time.Now()
+	for n, expires := range a.nonces {
+		if now.After(expires) {
+			delete(a.nonces, n)
+		}
+	}
+	if _, used := a.nonces[nonce]; used {
+		return false
+	}
+	a.nonces[nonce] = now.Add(2 * a.maxSkew)
+	return true
+}
+
+// Sign computes the HMAC-SHA256 signature of connect parameters
+func Sign(secret []byte, principal, ts, nonce string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(principal + "\n" + ts + "\n" + nonce))
+	return mac.Sum(nil)
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(origin), "/")
+}
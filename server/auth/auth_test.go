@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNoCredentialsRejectsEveryone(t *testing.T) {
+	a, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/ws", nil)
+	if _, err := a.Authenticate(r); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Authenticate without credentials configured: got %v, want %v", err, ErrUnauthorized)
+	}
+	r = httptest.NewRequest("GET", "/ws?token=anything", nil)
+	if _, err := a.Authenticate(r); err == nil {
+		t.Fatal("Authenticate accepted a token although none is configured")
+	}
+}
+
+func TestInsecureAcceptsAnonymous(t *testing.T) {
+	a, err := New(Config{Insecure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := a.Authenticate(httptest.NewRequest("GET", "/ws", nil))
+	if err != nil || p.Method != "none" {
+		t.Fatalf("Authenticate = %+v, %v; want anonymous principal", p, err)
+	}
+
+	// Insecure has no effect once credentials are configured
+	a, _ = New(Config{Insecure: true, Tokens: []Token{{Principal: "till", Token: "secret"}}})
+	if _, err := a.Authenticate(httptest.NewRequest("GET", "/ws", nil)); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Authenticate without token: got %v, want %v", err, ErrUnauthorized)
+	}
+}
+
+func TestDefaultOrigins(t *testing.T) {
+	a, _ := New(Config{Tokens: []Token{{Principal: "till", Token: "secret"}}})
+	for origin, want := range map[string]bool{
+		"":                      true,
+		"http://localhost:5173": true,
+		"file://":               false,
+		"https://evil.example":  false,
+	} {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if got := a.CheckOrigin(r); got != want {
+			t.Errorf("CheckOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
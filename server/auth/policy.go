@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Well-known roles. Policies may use any role name.
+const (
+	RoleCashier    = "cashier"
+	RoleSupervisor = "supervisor"
+	RoleTechnician = "technician"
+	RoleDisplay    = "display" // Customer-facing display
+)
+
+// Policy maps commands to the roles allowed to run them, and sets when a
+// refund needs a supervisor's approval
+type Policy struct {
+	// Commands lists the roles allowed for each command. Commands not
+	// listed are open to every authenticated principal, except
+	// ExplicitCommands.
+	Commands map[string][]string `json:"commands"`
+
+	// Topics lists the roles allowed to subscribe to each WebSocket topic.
+	// Topics not listed are open to every authenticated principal, except
+	// RestrictedTopics.
+	Topics map[string][]string `json:"topics,omitempty"`
+
+	// RefundApprovalThreshold is the REFUND/VOID amount (minor units) above
+	// which a second credential with an approver role is required. 0 disables.
+	RefundApprovalThreshold int64 `json:"refund_approval_threshold,omitempty"`
+
+	// ApproverRoles may approve refunds, default ["supervisor"]. Principals
+	// holding one of them need no second credential.
+	ApproverRoles []string `json:"approver_roles,omitempty"`
+
+	// Limits caps transaction amounts. An approver's credential overrides
+	// them; unlike refund approval, the caller cannot approve their own.
+	Limits *Limits `json:"limits,omitempty"`
+}
+
+// Limit caps the amounts of one transaction command, in minor units. Zero
+// fields are not limited.
+type Limit struct {
+	MaxAmount  int64 `json:"max_amount,omitempty"`  // Largest single transaction
+	DailyTotal int64 `json:"daily_total,omitempty"` // Total per calendar day, including the new one
+}
+
+// CommandLimits maps transaction commands ("SALE", "REFUND", "VOID") to
+// their limits
+type CommandLimits map[string]Limit
+
+// Limits are the amount limits of a policy
+type Limits struct {
+	// Terminal applies to every transaction on the terminal; daily totals
+	// count all of them
+	Terminal CommandLimits `json:"terminal,omitempty"`
+
+	// Terminals replaces Terminal for the commands listed, by terminal ID,
+	// so one policy can serve terminals with different limits
+	Terminals map[string]CommandLimits `json:"terminals,omitempty"`
+
+	// Operators applies to each principal's own transactions, by principal
+	// name; "*" applies to principals not listed
+	Operators map[string]CommandLimits `json:"operators,omitempty"`
+}
+
+// LoadPolicy reads a policy file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %v", err)
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", path, err)
+	}
+	if len(p.ApproverRoles) == 0 {
+		p.ApproverRoles = []string{RoleSupervisor}
+	}
+	if err := p.Limits.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", path, err)
+	}
+	return &p, nil
+}
+
+// ExplicitCommands are only allowed to roles the policy lists for them:
+// unlike other commands they are denied when not listed or when no policy
+// is loaded
+var ExplicitCommands = map[string]bool{
+	"RESTART_PROCESS": true,
+}
+
+// RestrictedCommands are limited to these roles unless the policy lists
+// them: resolving a transaction sets its outcome in the ledger
+var RestrictedCommands = map[string][]string{
+	"RESOLVE": {RoleSupervisor},
+}
+
+// Allowed reports whether principal may run command. Commands the policy
+// does not list, or every command with a nil policy, are allowed except
+// ExplicitCommands and RestrictedCommands.
+func (p *Policy) Allowed(principal Principal, command string) bool {
+	var roles []string
+	restricted := false
+	if p != nil {
+		roles, restricted = p.Commands[command]
+	}
+	if !restricted {
+		if ExplicitCommands[command] {
+			return false
+		}
+		roles, restricted = RestrictedCommands[command]
+	}
+	if !restricted {
+		return true
+	}
+	return principal.HasAnyRole(roles)
+}
+
+// RestrictedTopics are limited to these roles unless the policy lists
+// them: server logs carry transaction IDs, principals and amounts
+var RestrictedTopics = map[string][]string{
+	"logs": {RoleSupervisor, RoleTechnician},
+}
+
+// TopicAllowed reports whether principal may subscribe to topic. Topics the
+// policy does not list, or every topic with a nil policy, are open except
+// RestrictedTopics.
+func (p *Policy) TopicAllowed(principal Principal, topic string) bool {
+	var roles []string
+	restricted := false
+	if p != nil {
+		roles, restricted = p.Topics[topic]
+	}
+	if !restricted {
+		roles, restricted = RestrictedTopics[topic]
+	}
+	if !restricted {
+		return true
+	}
+	return principal.HasAnyRole(roles)
+}
+
+// NeedsApproval reports whether a refund or void of amount requires a
+// supervisor's approval
+func (p *Policy) NeedsApproval(command string, amount int64) bool {
+	if p == nil || p.RefundApprovalThreshold <= 0 {
+		return false
+	}
+	if command != "REFUND" && command != "VOID" {
+		return false
+	}
+	return amount > p.RefundApprovalThreshold
+}
+
+// CanApprove reports whether principal holds an approver role
+func (p *Policy) CanApprove(principal Principal) bool {
+	return p != nil && principal.HasAnyRole(p.ApproverRoles)
+}
+
+// TerminalLimit returns the limit of command on the terminal terminalID
+func (p *Policy) TerminalLimit(terminalID, command string) (Limit, bool) {
+	if p == nil || p.Limits == nil {
+		return Limit{}, false
+	}
+	if terminalID != "" {
+		if l, ok := p.Limits.Terminals[terminalID][command]; ok {
+			return l, true
+		}
+	}
+	l, ok := p.Limits.Terminal[command]
+	return l, ok
+}
+
+// OperatorLimit returns the limit of command for principal
+func (p *Policy) OperatorLimit(principal Principal, command string) (Limit, bool) {
+	if p == nil || p.Limits == nil {
+		return Limit{}, false
+	}
+	if limits, ok := p.Limits.Operators[principal.Name]; ok {
+		l, ok := limits[command]
+		return l, ok
+	}
+	l, ok := p.Limits.Operators["*"][command]
+	return l, ok
+}
+
+func (l *Limits) validate() error {
+	if l == nil {
+		return nil
+	}
+	check := func(scope string, limits CommandLimits) error {
+		for command, limit := range limits {
+			if limit.MaxAmount < 0 || limit.DailyTotal < 0 {
+				return fmt.Errorf("limits %s %s: amounts must not be negative", scope, command)
+			}
+		}
+		return nil
+	}
+	if err := check("terminal", l.Terminal); err != nil {
+		return err
+	}
+	for id, limits := range l.Terminals {
+		if err := check("terminal "+id, limits); err != nil {
+			return err
+		}
+	}
+	for name, limits := range l.Operators {
+		if err := check("operator "+name, limits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
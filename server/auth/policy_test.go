@@ -0,0 +1,61 @@
+package auth
+
+import "testing"
+
+func TestLogsTopicRestrictedByDefault(t *testing.T) {
+	cashier := Principal{Name: "till", Roles: []string{RoleCashier}}
+	technician := Principal{Name: "tech", Roles: []string{RoleTechnician}}
+	anonymous := Principal{Name: "anonymous", Method: "none"}
+
+	var nilPolicy *Policy
+	open := &Policy{Topics: map[string][]string{"logs": {RoleCashier}}}
+	unlisted := &Policy{Topics: map[string][]string{"ledger": {RoleSupervisor}}}
+
+	tests := []struct {
+		name      string
+		policy    *Policy
+		principal Principal
+		topic     string
+		want      bool
+	}{
+		{"nil policy, status", nilPolicy, anonymous, "status", true},
+		{"nil policy, logs, no role", nilPolicy, anonymous, "logs", false},
+		{"nil policy, logs, cashier", nilPolicy, cashier, "logs", false},
+		{"nil policy, logs, technician", nilPolicy, technician, "logs", true},
+		{"policy without logs, cashier", unlisted, cashier, "logs", false},
+		{"policy without logs, technician", unlisted, technician, "logs", true},
+		{"policy opening logs, cashier", open, cashier, "logs", true},
+		{"policy opening logs, technician", open, technician, "logs", false},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.TopicAllowed(tt.principal, tt.topic); got != tt.want {
+			t.Errorf("%s: TopicAllowed = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolveRestrictedByDefault(t *testing.T) {
+	cashier := Principal{Name: "till", Roles: []string{RoleCashier}}
+	supervisor := Principal{Name: "sup", Roles: []string{RoleSupervisor}}
+
+	var nilPolicy *Policy
+	open := &Policy{Commands: map[string][]string{"RESOLVE": {RoleCashier}}}
+	for _, tt := range []struct {
+		name      string
+		policy    *Policy
+		principal Principal
+		want      bool
+	}{
+		{"nil policy, cashier", nilPolicy, cashier, false},
+		{"nil policy, supervisor", nilPolicy, supervisor, true},
+		{"policy opening RESOLVE, cashier", open, cashier, true},
+		{"policy opening RESOLVE, supervisor", open, supervisor, false},
+	} {
+		if got := tt.policy.Allowed(tt.principal, "RESOLVE"); got != tt.want {
+			t.Errorf("%s: Allowed = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if !nilPolicy.Allowed(cashier, "SALE") || nilPolicy.Allowed(supervisor, "RESTART_PROCESS") {
+		t.Error("nil policy must allow SALE and deny RESTART_PROCESS")
+	}
+}
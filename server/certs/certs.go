@@ -0,0 +1,224 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"ecpay-server/logger"
+	"ecpay-server/metrics"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ReloadInterval is how often the certificate files are checked for changes
+const ReloadInterval = 1 * time.Minute
+
+// ExpiryWarning is how close to expiry a certificate starts being reported
+const ExpiryWarning = 30 * 24 * time.Hour
+
+// Certificate sources
+const (
+	SourceConfigured = "configured" // Cert/key pair given on the command line
+	SourceGenerated  = "generated"  // Leaf issued by the local CA
+)
+
+// Info describes the certificate being served
+type Info struct {
+	Source    string    `json:"source"`
+	CertFile  string    `json:"cert_file"`
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	DaysLeft  int       `json:"days_left"`
+	Expiring  bool      `json:"expiring"` // Expires within 30 days
+	LoadedAt  time.Time `json:"loaded_at"`
+	CAFile    string    `json:"ca_file,omitempty"` // Local CA to trust (generated certificates only)
+}
+
+// Manager serves a certificate for the TLS listener and reloads it when the
+// files on disk change, so certificates can be replaced without a restart
+type Manager struct {
+	certFile string
+	keyFile  string
+	source   string
+	caDir    string // Local CA directory for generated certificates
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	info     Info
+	modTime  time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewManager loads a configured certificate/key pair
+func NewManager(certFile, keyFile string) (*Manager, error) {
+	m := &Manager{certFile: certFile, keyFile: keyFile, source: SourceConfigured, stop: make(chan struct{})}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewLocalManager serves a localhost certificate issued by a local CA kept
+// in dir, generating both on first run. Trust dir/ca.pem on the client to
+// avoid browser warnings.
+func NewLocalManager(dir string) (*Manager, error) {
+	m := &Manager{
+		certFile: filepath.Join(dir, leafCertFile),
+		keyFile:  filepath.Join(dir, leafKeyFile),
+		source:   SourceGenerated,
+		caDir:    dir,
+		stop:     make(chan struct{}),
+	}
+	if err := ensureLocalCerts(dir, false); err != nil {
+		return nil, err
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil, errors.New("no certificate loaded")
+	}
+	return m.cert, nil
+}
+
+// TLSConfig returns a server TLS config serving the managed certificate
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// Info returns details of the certificate being served
+func (m *Manager) Info() Info {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	info := m.info
+	info.DaysLeft = int(time.Until(info.NotAfter).Hours() / 24)
+	info.Expiring = time.Until(info.NotAfter) < ExpiryWarning
+	return info
+}
+
+// Start watches the certificate files and reloads them when they change
+func (m *Manager) Start() {
+	go func() {
+		ticker := time.NewTicker(ReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.check()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops watching the certificate files
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// Reload reloads the certificate files now
+func (m *Manager) Reload() error {
+	return m.load()
+}
+
+// check reloads changed files and renews an expiring generated certificate
+func (m *Manager) check() {
+	if m.source == SourceGenerated && time.Until(m.Info().NotAfter) < ExpiryWarning {
+		logger.Info("Local TLS certificate expires soon, issuing a new one")
+		if err := ensureLocalCerts(m.caDir, true); err != nil {
+			logger.Error("Failed to renew local TLS certificate: %v", err)
+		}
+	}
+
+	modTime, err := m.filesModTime()
+	if err != nil {
+		logger.Warn("TLS certificate check failed: %v", err)
+		return
+	}
+	m.mu.RLock()
+	changed := !modTime.Equal(m.modTime)
+	m.mu.RUnlock()
+	if !changed {
+		return
+	}
+	if err := m.load(); err != nil {
+		// Keep serving the previous certificate
+		logger.Error("TLS certificate reload failed: %v", err)
+	}
+}
+
+// filesModTime returns the latest modification time of the cert and key
+func (m *Manager) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{m.certFile, m.keyFile} {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if stat.ModTime().After(latest) {
+			latest = stat.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (m *Manager) load() error {
+	modTime, err := m.filesModTime()
+	if err != nil {
+		return fmt.Errorf("failed to read TLS certificate: %v", err)
+	}
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse TLS certificate: %v", err)
+	}
+	cert.Leaf = leaf
+
+	info := Info{
+		Source:    m.source,
+		CertFile:  m.certFile,
+		Subject:   leaf.Subject.String(),
+		Issuer:    leaf.Issuer.String(),
+		DNSNames:  leaf.DNSNames,
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+		LoadedAt:  time.Now(),
+	}
+	if m.source == SourceGenerated {
+		info.CAFile = filepath.Join(m.caDir, caCertFile)
+	}
+
+	m.mu.Lock()
+	m.cert = &cert
+	m.info = info
+	m.modTime = modTime
+	m.mu.Unlock()
+
+	metrics.TLSCertExpiry.Set(float64(leaf.NotAfter.Unix()))
+	logger.Info("TLS certificate loaded: %s (expires %s)", info.Subject, leaf.NotAfter.Format(time.RFC3339))
+	if time.Until(leaf.NotAfter) < ExpiryWarning {
+		logger.Warn("TLS certificate %s expires %s", m.certFile, leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -1,18 +1,88 @@
 package config
 
 import (
+	"ecpay-server/auth"
+	"ecpay-server/hooks"
+	"ecpay-server/webhook"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"os"
+	"time"
 )
 
 type Config struct {
-	WSAddr string // WebSocket server address
+	WSAddr            string        // WebSocket server address
+	GRPCAddr          string        // gRPC server address (empty: disabled)
+	DataDir           string        // Directory for the transaction journal and other state
+	IdempotencyWindow time.Duration // How long idempotency keys are remembered
+	ShutdownTimeout   time.Duration // How long shutdown waits for a running transaction
+	ExitOnStdinClose  bool          // Shut down when stdin is closed (parent process gone or stopping us)
+	InsecureNoAuth    bool          // Accept unauthenticated clients when no credentials are configured
+	File              string        // Path of the JSON config file (optional)
+	PolicyFile        string        // Path of the command/role policy file (optional)
+	TLS               bool          // Serve wss:// and https://
+	TLSCert           string        // Certificate file (empty: generate a local CA and localhost certificate)
+	TLSKey            string        // Private key file
+
+	Auth     auth.Config      // Origin allowlist and client credentials
+	Webhooks []webhook.Target // Endpoints notified of transaction and terminal events
+	Hooks    []hooks.Config   // Executables and HTTP callouts run around transactions
+}
+
+// fileConfig is the layout of the JSON config file
+type fileConfig struct {
+	Auth     auth.Config      `json:"auth"`
+	Webhooks []webhook.Target `json:"webhooks"`
+	Hooks    []hooks.Config   `json:"hooks"`
 }
 
 func Load() *Config {
 	wsAddr := flag.String("ws", ":8989", "WebSocket server address")
+	grpcAddr := flag.String("grpc", "", "gRPC server address, e.g. :8990 (disabled if empty)")
+	dataDir := flag.String("data", "data", "Directory for transaction journal and state files")
+	idemWindow := flag.Duration("idempotency-window", 24*time.Hour, "How long idempotency keys are remembered")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 70*time.Second, "How long shutdown waits for a running transaction before aborting it")
+	exitOnStdinClose := flag.Bool("exit-on-stdin-close", false, "Shut down gracefully when stdin is closed (for process managers that cannot send signals)")
+	insecureNoAuth := flag.Bool("insecure-no-auth", false, "Run without credentials, accepting every local client (development only)")
+	file := flag.String("config", "", "JSON config file (auth, webhook and transaction hook settings)")
+	policyFile := flag.String("policy", "", "JSON policy file mapping commands to roles")
+	tlsEnabled := flag.Bool("tls", false, "Serve TLS (wss://); without -tls-cert a local CA and localhost certificate are generated")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file (PEM)")
+	tlsKey := flag.String("tls-key", "", "TLS private key file (PEM)")
 	flag.Parse()
 
 	return &Config{
-		WSAddr: *wsAddr,
+		WSAddr:            *wsAddr,
+		GRPCAddr:          *grpcAddr,
+		DataDir:           *dataDir,
+		IdempotencyWindow: *idemWindow,
+		ShutdownTimeout:   *shutdownTimeout,
+		ExitOnStdinClose:  *exitOnStdinClose,
+		InsecureNoAuth:    *insecureNoAuth,
+		File:              *file,
+		PolicyFile:        *policyFile,
+		TLS:               *tlsEnabled || *tlsCert != "",
+		TLSCert:           *tlsCert,
+		TLSKey:            *tlsKey,
+	}
+}
+
+// LoadFile reads the JSON config file, if one was given
+func (c *Config) LoadFile() error {
+	if c.File == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	var fc fileConfig
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", c.File, err)
 	}
+	c.Auth = fc.Auth
+	c.Webhooks = fc.Webhooks
+	c.Hooks = fc.Hooks
+	return nil
 }
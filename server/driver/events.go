@@ -0,0 +1,177 @@
+package driver
+
+import (
+	"ecpay-server/metrics"
+	"sync"
+	"sync/atomic"
+)
+
+// subscriberQueueSize is how many events a subscriber may fall behind
+// before further events are dropped for it
+const subscriberQueueSize = 256
+
+// Event is a driver event published on a Bus. Subscribers switch on the
+// concrete type.
+type Event interface {
+	driverEvent()
+}
+
+// StateChanged is published on every transaction state transition
+type StateChanged struct {
+	From   TransactionState
+	To     TransactionState
+	Status StatusInfo
+}
+
+// ConnectionChanged is published when the terminal connects or is lost
+type ConnectionChanged struct {
+	Connected bool
+	Status    StatusInfo
+}
+
+// ScanUpdated is published when a device scan cycle starts and ends
+type ScanUpdated struct {
+	Status ScannerStatus
+}
+
+// FrameSent is published for every frame or control byte written to the
+// terminal. TxnID is empty outside a transaction.
+type FrameSent struct {
+	TxnID string
+	Data  []byte
+}
+
+// FrameReceived is published for every frame or control byte read from the
+// terminal. TxnID is empty outside a transaction.
+type FrameReceived struct {
+	TxnID string
+	Data  []byte
+}
+
+// TransactionCompleted is published when ExecuteTransaction returns. Err is
+// an *UnknownOutcomeError if the outcome is unknown.
+type TransactionCompleted struct {
+	TxnID     string
+	RequestID string
+	TransType string
+	Amount    string
+	Result    map[string]string
+	Err       error
+}
+
+// TransactionReconciled is published when a late response or an operator
+// resolves an UNKNOWN transaction
+type TransactionReconciled struct {
+	Transaction UnresolvedTransaction
+}
+
+func (StateChanged) driverEvent()          {}
+func (ConnectionChanged) driverEvent()     {}
+func (ScanUpdated) driverEvent()           {}
+func (FrameSent) driverEvent()             {}
+func (FrameReceived) driverEvent()         {}
+func (TransactionCompleted) driverEvent()  {}
+func (TransactionReconciled) driverEvent() {}
+
+// flushMarker is queued by Flush and closed when the subscriber reaches it
+type flushMarker chan struct{}
+
+func (flushMarker) driverEvent() {}
+
+// Bus delivers driver events to any number of subscribers. Publish only
+// queues events, so it may be called with driver locks held; each
+// subscriber handles its events in order on its own goroutine.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscriber]struct{}
+}
+
+// Subscriber receives events from a Bus until Close
+type Subscriber struct {
+	bus       *Bus
+	name      string
+	queue     chan Event
+	done      chan struct{}
+	closeOnce sync.Once
+	dropped   atomic.Uint64
+}
+
+// NewBus creates a bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscriber]struct{})}
+}
+
+// Subscribe calls handler for every event published from now on. name
+// identifies the subscriber in metrics.
+func (b *Bus) Subscribe(name string, handler func(Event)) *Subscriber {
+	s := &Subscriber{
+		bus:   b,
+		name:  name,
+		queue: make(chan Event, subscriberQueueSize),
+		done:  make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		defer close(s.done)
+		for e := range s.queue {
+			if marker, ok := e.(flushMarker); ok {
+				close(marker)
+				continue
+			}
+			handler(e)
+		}
+	}()
+	return s
+}
+
+// Publish queues an event for every subscriber without blocking. A
+// subscriber whose queue is full misses the event.
+func (b *Bus) Publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		select {
+		case s.queue <- e:
+		default:
+			s.dropped.Add(1)
+			metrics.DriverEventsDropped.WithLabelValues(s.name).Inc()
+		}
+	}
+}
+
+// Close unsubscribes and waits for the handler to finish the events already
+// queued. It must not be called from the handler.
+func (s *Subscriber) Close() {
+	s.closeOnce.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		close(s.queue)
+	})
+	<-s.done
+}
+
+// Flush waits until the handler has finished every event published before
+// the call. It returns at once if the subscriber is closed. It must not be
+// called from the handler.
+func (s *Subscriber) Flush() {
+	marker := make(flushMarker)
+	s.bus.mu.RLock()
+	if _, ok := s.bus.subs[s]; !ok {
+		s.bus.mu.RUnlock()
+		return
+	}
+	// Blocks while the queue is full, unlike Publish: the marker must not
+	// be dropped
+	s.queue <- marker
+	s.bus.mu.RUnlock()
+	<-marker
+}
+
+// Dropped returns how many events the subscriber missed
+func (s *Subscriber) Dropped() uint64 {
+	return s.dropped.Load()
+}
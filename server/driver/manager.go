@@ -3,7 +3,9 @@ package driver
 import (
 	"bytes"
 	"context"
+	"ecpay-server/journal"
 	"ecpay-server/logger"
+	"ecpay-server/metrics"
 	"ecpay-server/protocol"
 	"errors"
 	"fmt"
@@ -13,18 +15,29 @@ import (
 
 // SerialManager manages the serial port connection and transaction execution
 type SerialManager struct {
-	Port    Port
-	State   *StateMachine
-	Scanner *Scanner
-	mu      sync.Mutex // Protects Port access during reconnection
+	Port       Port
+	Events     *Bus // Driver events, kept across Rebuild
+	State      *StateMachine
+	Scanner    *Scanner
+	Reconciler *Reconciler
+	Journal    *journal.Journal // Optional write-ahead transaction journal
+	mu         sync.Mutex       // Protects Port access during reconnection
+
+	// Late response listener (runs while idle with unresolved transactions)
+	lateMu   sync.Mutex
+	lateStop chan struct{}
+	lateDone chan struct{}
 }
 
 // NewSerialManager creates a new manager with optional initial port
 // If initialPort is nil, auto-detection scanner will be started
 func NewSerialManager(initialPort Port) *SerialManager {
+	events := NewBus()
 	sm := &SerialManager{
-		Port:  initialPort,
-		State: NewStateMachine(),
+		Port:       initialPort,
+		Events:     events,
+		State:      NewStateMachine(events),
+		Reconciler: NewReconciler(),
 	}
 
 	if initialPort != nil {
@@ -39,10 +52,35 @@ func NewSerialManager(initialPort Port) *SerialManager {
 	return sm
 }
 
+// Rebuild closes sm and returns a new manager, with a new scanner, that
+// takes over its event bus, journal and unresolved transactions. The new
+// scanner is not running until Start is called. Call it once no transaction
+// is running.
+func (sm *SerialManager) Rebuild() *SerialManager {
+	sm.Close()
+
+	next := &SerialManager{
+		Events:     sm.Events,
+		State:      NewStateMachine(sm.Events),
+		Reconciler: NewReconciler(),
+		Journal:    sm.Journal,
+	}
+	next.Scanner = NewScanner(next)
+	for _, tx := range sm.Reconciler.List() {
+		next.Reconciler.Add(tx)
+	}
+	return next
+}
+
+// Start starts the scanner of a manager returned by Rebuild
+func (sm *SerialManager) Start() {
+	sm.Scanner.Start()
+}
+
 // ConnectTo connects to a specific serial port
 func (sm *SerialManager) ConnectTo(portName string) bool {
+	sm.stopLateListener()
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
 
 	// Close existing connection if any
 	if sm.Port != nil {
@@ -55,17 +93,23 @@ func (sm *SerialManager) ConnectTo(portName string) bool {
 	if err != nil {
 		logger.Error("Failed to connect to %s: %v", portName, err)
 		sm.State.SetConnected(false)
+		sm.mu.Unlock()
 		return false
 	}
 
 	sm.Port = port
 	sm.State.SetConnected(true)
 	logger.Info("Connected to %s", portName)
+	sm.mu.Unlock()
+
+	// Resume listening for late responses on the new connection
+	sm.startLateListener()
 	return true
 }
 
 // Disconnect closes the current connection
 func (sm *SerialManager) Disconnect() {
+	sm.stopLateListener()
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -76,6 +120,16 @@ func (sm *SerialManager) Disconnect() {
 	sm.State.SetConnected(false)
 }
 
+// Close stops device scanning and closes the port. Call it once no
+// transaction is running.
+func (sm *SerialManager) Close() {
+	if sm.Scanner != nil {
+		sm.Scanner.Stop()
+	}
+	sm.Disconnect()
+	logger.Info("POS connection closed")
+}
+
 // IsConnected checks if a device is currently connected
 func (sm *SerialManager) IsConnected() bool {
 	return sm.State.IsConnected()
@@ -84,20 +138,48 @@ func (sm *SerialManager) IsConnected() bool {
 // ForceRescan triggers a manual scan for POS devices
 func (sm *SerialManager) ForceRescan() {
 	if sm.Scanner != nil {
-		go sm.Scanner.scanAndConnect()
+		sm.Scanner.rescan()
 	}
 }
 
-// SetStateCallback sets the callback for state changes
-func (sm *SerialManager) SetStateCallback(cb StateChangeCallback) {
-	sm.State.SetCallback(cb)
-}
-
 // GetStatus returns the current status
 func (sm *SerialManager) GetStatus() StatusInfo {
 	return sm.State.GetStatusInfo()
 }
 
+// SetJournal attaches the write-ahead journal and loads the transactions it
+// recovered as unresolved into the reconciler
+func (sm *SerialManager) SetJournal(j *journal.Journal, unresolved []journal.Entry) {
+	sm.Journal = j
+
+	for _, e := range unresolved {
+		reason := e.Error
+		if e.Phase == journal.PhaseInterrupted {
+			reason = "interrupted"
+			logger.Txn{ID: e.ID, RequestID: e.RequestID}.Warn("Interrupted transaction needs manual reconciliation: Type=%s Amount=%s OrderNo=%s (%s)",
+				e.TransType, e.Amount, e.OrderNo, e.Error)
+		}
+		sm.Reconciler.Add(UnresolvedTransaction{
+			ID:          e.ID,
+			TransType:   e.TransType,
+			Amount:      e.Amount,
+			OrderNo:     e.OrderNo,
+			RequestHash: e.FrameHash,
+			PosTime:     e.PosTime,
+			RequestID:   e.RequestID,
+			SentAt:      e.CreatedAt,
+			Reason:      reason,
+		})
+	}
+
+	sm.startLateListener()
+}
+
+// UnresolvedTransactions returns transactions whose outcome is still unknown
+func (sm *SerialManager) UnresolvedTransactions() []UnresolvedTransaction {
+	return sm.Reconciler.List()
+}
+
 // AbortTransaction attempts to cancel the current transaction
 func (sm *SerialManager) AbortTransaction() bool {
 	return sm.State.Abort()
@@ -105,20 +187,42 @@ func (sm *SerialManager) AbortTransaction() bool {
 
 // ExecuteTransaction executes a complete ECPay transaction
 // Flow: Send -> Wait ACK -> Wait Response -> Send ACK -> Parse
-func (sm *SerialManager) ExecuteTransaction(req protocol.ECPayRequest) (map[string]string, error) {
-	logger.Info("Starting transaction: Type=%s Amount=%s OrderNo=%s", req.TransType, req.Amount, req.OrderNo)
+// If the request was sent but no response arrives (timeout or abort), the
+// transaction is recorded as UNKNOWN and an *UnknownOutcomeError is returned.
+// requestID is the client's correlation ID; it is echoed on status updates
+// and tagged on every log line together with txnID. The outcome is also
+// published as TransactionCompleted.
+func (sm *SerialManager) ExecuteTransaction(txnID, requestID string, req protocol.ECPayRequest) (map[string]string, error) {
+	result, err := sm.executeTransaction(txnID, requestID, req)
+	sm.Events.Publish(TransactionCompleted{
+		TxnID:     txnID,
+		RequestID: requestID,
+		TransType: req.TransType,
+		Amount:    req.Amount.String(),
+		Result:    result,
+		Err:       err,
+	})
+	return result, err
+}
+
+func (sm *SerialManager) executeTransaction(txnID, requestID string, req protocol.ECPayRequest) (map[string]string, error) {
+	tx := logger.Txn{ID: txnID, RequestID: requestID}
+	tx.Info("Starting transaction: Type=%s Amount=%s OrderNo=%s", req.TransType, req.Amount, req.OrderNo)
 
 	// Check connection
 	if !sm.IsConnected() || sm.Port == nil {
-		return nil, errors.New("POS device not connected")
+		return nil, ErrNotConnected
 	}
 
 	// Check if we can start a transaction
-	if err := sm.State.StartTransaction(req.TransType, req.Amount); err != nil {
-		logger.Error("Cannot start transaction: %v", err)
+	if err := sm.State.StartTransaction(txnID, requestID, req.TransType, req.Amount.String()); err != nil {
+		tx.Error("Cannot start transaction: %v", err)
 		return nil, err
 	}
 
+	// Take the port back from the late response listener
+	sm.stopLateListener()
+
 	// Ensure we always reset to IDLE when done
 	defer func() {
 		// Give UI time to see error state before resetting
@@ -126,7 +230,8 @@ func (sm *SerialManager) ExecuteTransaction(req protocol.ECPayRequest) (map[stri
 			time.Sleep(2 * time.Second)
 		}
 		sm.State.Reset()
-		logger.Debug("Transaction state reset to IDLE")
+		tx.Debug("Transaction state reset to IDLE")
+		sm.startLateListener()
 	}()
 
 	// Create context with overall timeout (70s covers all phases)
@@ -138,99 +243,180 @@ func (sm *SerialManager) ExecuteTransaction(req protocol.ECPayRequest) (map[stri
 
 	// 1. Build packet
 	sm.State.TransitionTo(StateSending)
-	logger.Debug("Building packet...")
+	tx.Debug("Building packet...")
+	if req.PosTime == "" {
+		req.PosTime = time.Now().Format("20060102150405")
+	}
 	packet := protocol.BuildPacket(req)
+	pending := UnresolvedTransaction{
+		ID:          txnID,
+		RequestID:   requestID,
+		TransType:   req.TransType,
+		Amount:      req.Amount.String(),
+		OrderNo:     req.OrderNo,
+		RequestHash: protocol.FrameRequestHash(packet),
+		PosTime:     req.PosTime,
+	}
+
+	// Journal the transaction before the frame can reach the terminal
+	if sm.Journal != nil {
+		err := sm.Journal.Begin(journal.Entry{
+			ID:        txnID,
+			RequestID: requestID,
+			Phase:     journal.PhaseSending,
+			TransType: req.TransType,
+			HostID:    req.HostID,
+			Amount:    req.Amount.String(),
+			OrderNo:   req.OrderNo,
+			PosTime:   req.PosTime,
+			FrameHash: pending.RequestHash,
+		})
+		if err != nil {
+			tx.Error("Refusing to send frame: %v", err)
+			sm.State.TransitionToError(err.Error())
+			return nil, err
+		}
+	}
 
 	// 2. Clear input buffer
 	if err := sm.Port.ResetInputBuffer(); err != nil {
-		logger.Warn("Failed to reset input buffer: %v", err)
+		tx.Warn("Failed to reset input buffer: %v", err)
 	}
 
 	// 3. Send packet
 	_, err := sm.Port.Write(packet)
 	if err != nil {
-		sm.handleWriteError(err)
+		sm.handleWriteError(tx, err)
+		sm.journalUpdate(tx, journal.PhaseError, err.Error())
 		return nil, fmt.Errorf("write error: %v", err)
 	}
-	logger.Debug("Packet sent (%d bytes)", len(packet))
+	pending.SentAt = time.Now()
+	sm.Events.Publish(FrameSent{TxnID: txnID, Data: packet})
+	tx.Debug("Packet sent (%d bytes)", len(packet))
 
 	// 4. Wait for ACK (5s timeout)
-	sm.State.TransitionTo(StateWaitACK)
-	ackResult, err := sm.waitForACK(ctx, cancelChan)
+	sm.transition(tx, StateWaitACK)
+	ackStart := time.Now()
+	ackResult, err := sm.waitForACK(ctx, tx, cancelChan)
 	if err != nil {
 		if errors.Is(err, context.Canceled) || err.Error() == "aborted" {
-			return nil, errors.New("transaction aborted")
+			return nil, sm.markUnknown(pending, "aborted")
+		}
+		if errors.Is(err, context.DeadlineExceeded) || err.Error() == "timeout waiting for ACK" {
+			sm.State.TransitionToError(err.Error())
+			return nil, sm.markUnknown(pending, "timeout")
 		}
-		sm.State.TransitionToError(err.Error())
+		sm.fail(tx, err.Error())
 		return nil, err
 	}
+	metrics.PhaseDuration.WithLabelValues(metrics.PhaseACKWait).Observe(time.Since(ackStart).Seconds())
 	if !ackResult {
-		sm.State.TransitionToError("received NAK from POS")
+		metrics.NAKs.Inc()
+		sm.fail(tx, "received NAK from POS")
 		return nil, errors.New("received NAK from POS")
 	}
-	logger.Debug("ACK received")
+	tx.Debug("ACK received")
 
 	// 5. Wait for Response (65s timeout - user interaction time)
-	sm.State.TransitionTo(StateWaitResponse)
-	logger.Info("Waiting for POS response (card operation)...")
+	sm.transition(tx, StateWaitResponse)
+	tx.Info("Waiting for POS response (card operation)...")
 
-	responsePacket, err := sm.waitForResponse(ctx, cancelChan)
+	responseStart := time.Now()
+	responsePacket, err := sm.waitForResponse(ctx, tx, cancelChan, pending.RequestHash, pending.PosTime)
 	if err != nil {
 		if errors.Is(err, context.Canceled) || err.Error() == "aborted" {
-			return nil, errors.New("transaction aborted")
+			return nil, sm.markUnknown(pending, "aborted")
 		}
-		if err.Error() == "timeout" {
+		if errors.Is(err, context.DeadlineExceeded) || err.Error() == "timeout" {
 			sm.State.TransitionToTimeout()
-			return nil, errors.New("transaction timeout")
+			return nil, sm.markUnknown(pending, "timeout")
 		}
-		sm.State.TransitionToError(err.Error())
+		sm.fail(tx, err.Error())
 		return nil, err
 	}
 
+	metrics.PhaseDuration.WithLabelValues(metrics.PhaseResponseWait).Observe(time.Since(responseStart).Seconds())
+	sm.Events.Publish(FrameReceived{TxnID: txnID, Data: responsePacket})
+
 	// 6. Parse response
-	sm.State.TransitionTo(StateParsing)
+	sm.transition(tx, StateParsing)
 
 	// Validate packet LRC
 	if !protocol.ValidatePacket(responsePacket) {
-		sm.State.TransitionToError("invalid packet checksum")
+		metrics.LRCFailures.Inc()
+		sm.fail(tx, "invalid packet checksum")
 		return nil, errors.New("invalid packet checksum")
 	}
 
 	// Send ACK back to POS
 	if _, err := sm.Port.Write([]byte{protocol.ACK}); err != nil {
-		logger.Warn("Failed to send ACK: %v", err)
+		tx.Warn("Failed to send ACK: %v", err)
+	} else {
+		sm.Events.Publish(FrameSent{TxnID: txnID, Data: []byte{protocol.ACK}})
 	}
 
 	// Parse response fields
 	result := protocol.ParseResponse(responsePacket)
-	logger.Info("Response parsed: RespCode=%s ApprovalNo=%s", result["RespCode"], result["ApprovalNo"])
+	tx.Info("Response parsed: RespCode=%s ApprovalNo=%s", result["RespCode"], result["ApprovalNo"])
 
 	// Check response code
 	if respCode, ok := result["RespCode"]; ok && respCode != "0000" {
 		errMsg := fmt.Sprintf("transaction declined: %s", respCode)
-		sm.State.TransitionToError(errMsg)
+		sm.fail(tx, errMsg)
 		return result, errors.New(errMsg)
 	}
 
 	// Success
-	sm.State.TransitionTo(StateSuccess)
+	sm.transition(tx, StateSuccess)
 	return result, nil
 }
 
+// transition moves the state machine and journals the new phase
+func (sm *SerialManager) transition(tx logger.Txn, state TransactionState) {
+	sm.State.TransitionTo(state)
+	sm.journalUpdate(tx, state.String(), "")
+}
+
+// fail moves the state machine to ERROR and journals the failure
+func (sm *SerialManager) fail(tx logger.Txn, errMsg string) {
+	tx.Error("Transaction failed: %s", errMsg)
+	sm.State.TransitionToError(errMsg)
+	sm.journalUpdate(tx, journal.PhaseError, errMsg)
+}
+
+// journalUpdate records a phase change if a journal is attached
+func (sm *SerialManager) journalUpdate(tx logger.Txn, phase, errMsg string) {
+	if sm.Journal == nil {
+		return
+	}
+	if err := sm.Journal.Update(tx.ID, phase, errMsg); err != nil {
+		tx.Error("Journal update failed: %v", err)
+	}
+}
+
+// markUnknown records a sent transaction whose result was not received
+func (sm *SerialManager) markUnknown(pending UnresolvedTransaction, reason string) error {
+	pending.Reason = reason
+	sm.journalUpdate(logger.Txn{ID: pending.ID, RequestID: pending.RequestID}, journal.PhaseUnknown, reason)
+	sm.Reconciler.Add(pending)
+	pending.Outcome = OutcomeUnknown
+	return &UnknownOutcomeError{Transaction: pending}
+}
+
 // handleWriteError handles write errors and marks connection as lost
-func (sm *SerialManager) handleWriteError(err error) {
-	logger.Error("Write error (connection may be lost): %v", err)
+func (sm *SerialManager) handleWriteError(tx logger.Txn, err error) {
+	tx.Error("Write error (connection may be lost): %v", err)
 	sm.State.TransitionToError(fmt.Sprintf("write error: %v", err))
 	sm.State.SetConnected(false)
 
 	// Trigger rescan to find device again
 	if sm.Scanner != nil {
-		go sm.Scanner.scanAndConnect()
+		sm.Scanner.rescan()
 	}
 }
 
 // waitForACK waits for ACK/NAK with timeout and cancellation support
-func (sm *SerialManager) waitForACK(ctx context.Context, cancelChan <-chan struct{}) (bool, error) {
+func (sm *SerialManager) waitForACK(ctx context.Context, tx logger.Txn, cancelChan <-chan struct{}) (bool, error) {
 	timeout := time.After(5 * time.Second)
 	buf := make([]byte, 64)
 
@@ -246,25 +432,25 @@ func (sm *SerialManager) waitForACK(ctx context.Context, cancelChan <-chan struc
 			n, err := sm.Port.Read(buf)
 			if n > 0 {
 				for i := 0; i < n; i++ {
-					if buf[i] == protocol.ACK {
-						return true, nil
-					}
-					if buf[i] == protocol.NAK {
-						return false, nil
+					if buf[i] == protocol.ACK || buf[i] == protocol.NAK {
+						sm.Events.Publish(FrameReceived{TxnID: tx.ID, Data: []byte{buf[i]}})
+						return buf[i] == protocol.ACK, nil
 					}
 				}
 			}
 			// Ignore timeout errors from Read (expected due to SetReadTimeout)
 			if err != nil && !isTimeoutError(err) {
-				logger.Warn("Read error during ACK wait: %v", err)
+				tx.Warn("Read error during ACK wait: %v", err)
 			}
 			time.Sleep(50 * time.Millisecond)
 		}
 	}
 }
 
-// waitForResponse waits for complete response packet
-func (sm *SerialManager) waitForResponse(ctx context.Context, cancelChan <-chan struct{}) ([]byte, error) {
+// waitForResponse waits for the response packet matching requestHash and
+// posTime. Frames answering an earlier, unresolved transaction are
+// reconciled and skipped.
+func (sm *SerialManager) waitForResponse(ctx context.Context, tx logger.Txn, cancelChan <-chan struct{}, requestHash, posTime string) ([]byte, error) {
 	timeout := time.After(65 * time.Second)
 	buf := make([]byte, 1024)
 	respBuffer := new(bytes.Buffer)
@@ -283,21 +469,21 @@ func (sm *SerialManager) waitForResponse(ctx context.Context, cancelChan <-chan
 				respBuffer.Write(buf[:n])
 
 				// Check for complete packet (STX + 600 DATA + ETX + LRC = 603 bytes)
-				data := respBuffer.Bytes()
-				idxStx := bytes.IndexByte(data, protocol.STX)
-				idxEtx := bytes.LastIndexByte(data, protocol.ETX)
-
-				if idxStx >= 0 && idxEtx > idxStx && len(data) >= idxEtx+2 {
-					// Extract complete packet (STX to LRC inclusive)
-					packetData := data[idxStx : idxEtx+2]
-					if len(packetData) == 603 {
-						return packetData, nil
+				for {
+					packetData := extractFrame(respBuffer)
+					if packetData == nil {
+						break
+					}
+					if !answers(packetData, requestHash, posTime) {
+						sm.handleLateFrame(sm.Port, packetData)
+						continue
 					}
+					return packetData, nil
 				}
 			}
 			// Ignore timeout errors from Read
 			if err != nil && !isTimeoutError(err) {
-				logger.Warn("Read error during response wait: %v", err)
+				tx.Warn("Read error during response wait: %v", err)
 			}
 			time.Sleep(100 * time.Millisecond)
 		}
@@ -307,6 +493,7 @@ func (sm *SerialManager) waitForResponse(ctx context.Context, cancelChan <-chan
 // Reconnect attempts to reconnect to the POS device
 func (sm *SerialManager) Reconnect() error {
 	logger.Info("Reconnect requested...")
+	metrics.Reconnects.Inc()
 
 	// Mark as disconnected
 	sm.Disconnect()
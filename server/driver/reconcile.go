@@ -0,0 +1,352 @@
+package driver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"ecpay-server/logger"
+	"ecpay-server/metrics"
+	"ecpay-server/protocol"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Outcome values for transactions whose result was not received in time
+const (
+	OutcomeUnknown  = "UNKNOWN"
+	OutcomeApproved = "APPROVED"
+	OutcomeDeclined = "DECLINED"
+)
+
+// LateResponseWindow is how long the driver keeps listening for a late
+// response after a transaction timed out or was aborted. Older transactions
+// are no longer matched to responses and wait for an operator.
+const LateResponseWindow = 10 * time.Minute
+
+// UnresolvedTransaction is a transaction that was sent to the POS but whose
+// result was never received (timeout or user abort). The terminal may still
+// have approved the card, so the outcome stays UNKNOWN until a late response
+// is matched or an operator reconciles it manually.
+type UnresolvedTransaction struct {
+	ID          string            `json:"transaction_id"`
+	RequestID   string            `json:"request_id,omitempty"`
+	TransType   string            `json:"trans_type"`
+	Amount      string            `json:"amount,omitempty"`
+	OrderNo     string            `json:"order_no,omitempty"`
+	RequestHash string            `json:"request_hash"`
+	PosTime     string            `json:"pos_time"`
+	SentAt      time.Time         `json:"sent_at"`
+	Reason      string            `json:"reason"`
+	Outcome     string            `json:"outcome"`
+	ResolvedAt  time.Time         `json:"resolved_at,omitempty"`
+	Result      map[string]string `json:"result,omitempty"`
+	ResolvedBy  string            `json:"resolved_by,omitempty"` // Operator who resolved it manually
+	Note        string            `json:"note,omitempty"`        // The operator's reason
+}
+
+// UnknownOutcomeError is returned when a transaction was sent but its result
+// could not be determined
+type UnknownOutcomeError struct {
+	Transaction UnresolvedTransaction
+}
+
+func (e *UnknownOutcomeError) Error() string {
+	return fmt.Sprintf("transaction %s (outcome unknown)", e.Transaction.Reason)
+}
+
+// NewTransactionID generates a unique transaction identifier
+func NewTransactionID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().Format("20060102150405") + "-" + hex.EncodeToString(b)
+}
+
+// Reconciler tracks transactions with unknown outcome and matches late
+// response frames to them by request hash and POS request time
+type Reconciler struct {
+	mu      sync.Mutex
+	pending map[string]*UnresolvedTransaction
+}
+
+// NewReconciler creates an empty reconciler
+func NewReconciler() *Reconciler {
+	return &Reconciler{
+		pending: make(map[string]*UnresolvedTransaction),
+	}
+}
+
+// Add records a transaction with unknown outcome
+func (r *Reconciler) Add(tx UnresolvedTransaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx.Outcome = OutcomeUnknown
+	r.pending[tx.ID] = &tx
+	logger.Txn{ID: tx.ID, RequestID: tx.RequestID}.Warn("Marked UNKNOWN (%s), hash=%s", tx.Reason, tx.RequestHash)
+}
+
+// List returns all unresolved transactions, oldest first
+func (r *Reconciler) List() []UnresolvedTransaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]UnresolvedTransaction, 0, len(r.pending))
+	for _, tx := range r.pending {
+		list = append(list, *tx)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].SentAt.Before(list[j].SentAt)
+	})
+	return list
+}
+
+// HasListening reports whether any unresolved transaction is still inside
+// the late response window
+func (r *Reconciler) HasListening() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, tx := range r.pending {
+		if listening(tx, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// listening reports whether tx is still inside the late response window
+func listening(tx *UnresolvedTransaction, now time.Time) bool {
+	return now.Sub(tx.SentAt) < LateResponseWindow
+}
+
+// answers reports whether a response frame answers the request with the
+// given hash and POS request time. The terminal echoes both fields; one it
+// left blank is not held against the request.
+func answers(packet []byte, requestHash, posTime string) bool {
+	hash := protocol.FrameRequestHash(packet)
+	frameTime := protocol.FramePosTime(packet)
+	return (hash == "" || hash == requestHash) && (frameTime == "" || frameTime == posTime)
+}
+
+// Match resolves the unresolved transaction a response frame answers (see
+// answers). The request hash does not cover POS request time, so identical
+// requests share a hash and only the time tells them apart; a frame without
+// one goes to the oldest. Transactions past LateResponseWindow are not
+// matched. Returns false if the frame matches no pending transaction.
+func (r *Reconciler) Match(packet []byte) (UnresolvedTransaction, bool) {
+	if protocol.FrameRequestHash(packet) == "" {
+		return UnresolvedTransaction{}, false
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	var found *UnresolvedTransaction
+	for _, tx := range r.pending {
+		if !listening(tx, now) || !answers(packet, tx.RequestHash, tx.PosTime) {
+			continue
+		}
+		if found == nil || tx.SentAt.Before(found.SentAt) {
+			found = tx
+		}
+	}
+	if found == nil {
+		r.mu.Unlock()
+		return UnresolvedTransaction{}, false
+	}
+
+	result := protocol.ParseResponse(packet)
+	found.Result = result
+	found.ResolvedAt = time.Now()
+	if result["RespCode"] == "0000" {
+		found.Outcome = OutcomeApproved
+	} else {
+		found.Outcome = OutcomeDeclined
+	}
+	delete(r.pending, found.ID)
+	tx := *found
+	r.mu.Unlock()
+
+	logger.Txn{ID: tx.ID, RequestID: tx.RequestID}.Info("Late response reconciled: Outcome=%s RespCode=%s OrderNo=%s",
+		tx.Outcome, result["RespCode"], result["OrderNo"])
+	return tx, true
+}
+
+// Resolve removes an unresolved transaction with the outcome an operator
+// determined. Returns false if id is not unresolved.
+func (r *Reconciler) Resolve(id, outcome, resolvedBy, note string) (UnresolvedTransaction, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	found, ok := r.pending[id]
+	if !ok {
+		return UnresolvedTransaction{}, false
+	}
+	delete(r.pending, id)
+	found.Outcome = outcome
+	found.ResolvedAt = time.Now()
+	found.ResolvedBy = resolvedBy
+	found.Note = note
+	return *found, true
+}
+
+// Resolve records the outcome an operator determined for an unresolved
+// transaction, e.g. from the terminal's own report, and publishes it as
+// TransactionReconciled. outcome is OutcomeApproved or OutcomeDeclined.
+func (sm *SerialManager) Resolve(id, outcome, resolvedBy, note string) (UnresolvedTransaction, error) {
+	if outcome != OutcomeApproved && outcome != OutcomeDeclined {
+		return UnresolvedTransaction{}, fmt.Errorf("outcome must be %s or %s", OutcomeApproved, OutcomeDeclined)
+	}
+	tx, ok := sm.Reconciler.Resolve(id, outcome, resolvedBy, note)
+	if !ok {
+		return UnresolvedTransaction{}, fmt.Errorf("transaction %s is not unresolved", id)
+	}
+	log := logger.Txn{ID: tx.ID, RequestID: tx.RequestID}
+	summary := "resolved by " + resolvedBy
+	if note != "" {
+		summary += ": " + note
+	}
+	log.Info("Outcome %s %s", outcome, summary)
+	if sm.Journal != nil {
+		if err := sm.Journal.ResolveManually(tx.ID, outcome, summary); err != nil {
+			log.Error("Journal update failed: %v", err)
+		}
+	}
+	sm.Events.Publish(TransactionReconciled{Transaction: tx})
+	return tx, nil
+}
+
+// startLateListener starts reading the port for late response frames while
+// the driver is idle. It is a no-op if nothing is waiting or a listener is
+// already running.
+func (sm *SerialManager) startLateListener() {
+	sm.lateMu.Lock()
+	defer sm.lateMu.Unlock()
+
+	if sm.lateStop != nil || !sm.Reconciler.HasListening() {
+		return
+	}
+
+	sm.mu.Lock()
+	port := sm.Port
+	sm.mu.Unlock()
+	if port == nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	sm.lateStop = stop
+	sm.lateDone = done
+
+	go func() {
+		defer close(done)
+		sm.listenForLateFrames(port, stop)
+
+		sm.lateMu.Lock()
+		if sm.lateStop == stop {
+			sm.lateStop, sm.lateDone = nil, nil
+		}
+		sm.lateMu.Unlock()
+	}()
+}
+
+// stopLateListener stops the late response listener and waits for it to
+// release the port
+func (sm *SerialManager) stopLateListener() {
+	sm.lateMu.Lock()
+	stop, done := sm.lateStop, sm.lateDone
+	sm.lateStop, sm.lateDone = nil, nil
+	sm.lateMu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+func (sm *SerialManager) listenForLateFrames(port Port, stop <-chan struct{}) {
+	logger.Info("Listening for late POS responses...")
+	buf := make([]byte, 1024)
+	respBuffer := new(bytes.Buffer)
+	check := time.NewTicker(5 * time.Second)
+	defer check.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-check.C:
+			if !sm.Reconciler.HasListening() {
+				logger.Info("Late response window closed")
+				return
+			}
+		default:
+			n, err := port.Read(buf)
+			if n > 0 {
+				respBuffer.Write(buf[:n])
+				for {
+					packet := extractFrame(respBuffer)
+					if packet == nil {
+						break
+					}
+					sm.handleLateFrame(port, packet)
+				}
+			}
+			if err != nil && !isTimeoutError(err) {
+				logger.Warn("Late response listener stopped: %v", err)
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+// handleLateFrame acknowledges and reconciles a response frame that arrived
+// after its transaction was given up on
+func (sm *SerialManager) handleLateFrame(port Port, packet []byte) {
+	sm.Events.Publish(FrameReceived{Data: packet})
+	if !protocol.ValidatePacket(packet) {
+		metrics.LRCFailures.Inc()
+		logger.Warn("Discarding late frame with invalid checksum")
+		return
+	}
+	if _, err := port.Write([]byte{protocol.ACK}); err != nil {
+		logger.Warn("Failed to ACK late frame: %v", err)
+	} else {
+		sm.Events.Publish(FrameSent{Data: []byte{protocol.ACK}})
+	}
+	tx, ok := sm.Reconciler.Match(packet)
+	if !ok {
+		logger.Warn("Late frame matches no unresolved transaction (hash=%s)", protocol.FrameRequestHash(packet))
+		return
+	}
+	if sm.Journal != nil {
+		if err := sm.Journal.Resolve(tx.ID, tx.Outcome); err != nil {
+			logger.Txn{ID: tx.ID, RequestID: tx.RequestID}.Error("Journal update failed: %v", err)
+		}
+	}
+	sm.Events.Publish(TransactionReconciled{Transaction: tx})
+}
+
+// extractFrame removes and returns the first complete 603-byte frame from
+// buf, discarding any garbage before STX. Returns nil if none is complete.
+func extractFrame(buf *bytes.Buffer) []byte {
+	data := buf.Bytes()
+	idxStx := bytes.IndexByte(data, protocol.STX)
+	if idxStx < 0 {
+		buf.Reset()
+		return nil
+	}
+	if len(data)-idxStx < 603 {
+		return nil
+	}
+
+	packet := make([]byte, 603)
+	copy(packet, data[idxStx:idxStx+603])
+	buf.Next(idxStx + 603)
+	return packet
+}
@@ -0,0 +1,86 @@
+package driver
+
+import (
+	"ecpay-server/protocol"
+	"strings"
+	"testing"
+	"time"
+)
+
+// response builds the terminal's answer to a sale sent at posTime
+func response(amount protocol.Money, posTime, respCode string) []byte {
+	frame := protocol.BuildPacket(protocol.ECPayRequest{TransType: "01", HostID: "01", Amount: amount, PosTime: posTime})
+	copy(frame[1+61:], respCode)
+	return frame
+}
+
+// unresolved records a sale sent at posTime as UNKNOWN
+func unresolved(r *Reconciler, id string, amount protocol.Money, posTime string, sentAt time.Time) {
+	frame := protocol.BuildPacket(protocol.ECPayRequest{TransType: "01", HostID: "01", Amount: amount, PosTime: posTime})
+	r.Add(UnresolvedTransaction{
+		ID:          id,
+		TransType:   "SALE",
+		RequestHash: protocol.FrameRequestHash(frame),
+		PosTime:     protocol.FramePosTime(frame),
+		SentAt:      sentAt,
+		Reason:      "timeout",
+	})
+}
+
+func TestMatchByHashAndPosTime(t *testing.T) {
+	r := NewReconciler()
+	now := time.Now()
+	// The same sale twice: identical hashes, told apart by POS time
+	unresolved(r, "first", 10000, "20260116095100", now.Add(-2*time.Minute))
+	unresolved(r, "second", 10000, "20260116095200", now.Add(-time.Minute))
+	unresolved(r, "other", 25000, "20260116095200", now.Add(-time.Minute))
+
+	tx, ok := r.Match(response(10000, "20260116095200", "0000"))
+	if !ok || tx.ID != "second" || tx.Outcome != OutcomeApproved {
+		t.Fatalf("Match = %s %s, %v; want second APPROVED", tx.ID, tx.Outcome, ok)
+	}
+	if list := r.List(); len(list) != 2 || list[0].ID != "first" || list[1].ID != "other" {
+		t.Fatalf("still unresolved: %+v, want first and other", list)
+	}
+}
+
+func TestMatchByHashAlone(t *testing.T) {
+	r := NewReconciler()
+	now := time.Now()
+	unresolved(r, "newer", 10000, "20260116095200", now.Add(-time.Minute))
+	unresolved(r, "older", 10000, "20260116095100", now.Add(-2*time.Minute))
+
+	// A POS time that was never sent belongs to some other request
+	if tx, ok := r.Match(response(10000, "20260116095900", "0000")); ok {
+		t.Fatalf("frame of another POS time matched %s", tx.ID)
+	}
+
+	// Without a POS time the hash decides, oldest first
+	frame := response(10000, "20260116095900", "1001")
+	copy(frame[1+492:1+506], strings.Repeat(" ", 14))
+	tx, ok := r.Match(frame)
+	if !ok || tx.ID != "older" || tx.Outcome != OutcomeDeclined {
+		t.Fatalf("Match = %s %s, %v; want older DECLINED", tx.ID, tx.Outcome, ok)
+	}
+
+	// POS time alone matches nothing
+	if tx, ok := r.Match(response(99900, "20260116095200", "0000")); ok {
+		t.Fatalf("frame of another amount matched %s by POS time", tx.ID)
+	}
+}
+
+func TestMatchSkipsExpired(t *testing.T) {
+	r := NewReconciler()
+	unresolved(r, "stale", 10000, "20260116095100", time.Now().Add(-LateResponseWindow-time.Minute))
+
+	if r.HasListening() {
+		t.Fatal("HasListening with only an expired transaction")
+	}
+	if tx, ok := r.Match(response(10000, "20260116095100", "0000")); ok {
+		t.Fatalf("expired transaction %s matched", tx.ID)
+	}
+	// It stays listed for an operator to resolve
+	if list := r.List(); len(list) != 1 || list[0].ID != "stale" {
+		t.Fatalf("unresolved = %+v, want stale", list)
+	}
+}
@@ -2,10 +2,12 @@ package driver
 
 import (
 	"ecpay-server/logger"
+	"ecpay-server/metrics"
 	"ecpay-server/protocol"
 	"fmt"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"go.bug.st/serial"
@@ -15,6 +17,22 @@ import (
 type Scanner struct {
 	Manager *SerialManager
 	stop    chan struct{}
+	active  sync.WaitGroup // Scanning goroutines, waited for by Stop
+
+	mu         sync.Mutex
+	scanning   bool
+	stopped    bool
+	lastScan   time.Time
+	lastResult string
+	lastPort   string
+}
+
+// ScannerStatus reports what the scanner is doing, for health checks
+type ScannerStatus struct {
+	State      string     `json:"state"` // "scanning", "idle", "stopped"
+	LastScan   *time.Time `json:"last_scan,omitempty"`
+	LastResult string     `json:"last_result,omitempty"` // "found", "not_found"
+	LastPort   string     `json:"last_port,omitempty"`
 }
 
 func NewScanner(manager *SerialManager) *Scanner {
@@ -26,7 +44,9 @@ func NewScanner(manager *SerialManager) *Scanner {
 
 // Start begins the scanning loop
 func (s *Scanner) Start() {
+	s.active.Add(1)
 	go func() {
+		defer s.active.Done()
 		logger.Info("Starting POS device scanner...")
 
 		// Initial burst scan
@@ -34,7 +54,12 @@ func (s *Scanner) Start() {
 			if s.scanAndConnect() {
 				return
 			}
-			time.Sleep(1 * time.Second)
+			select {
+			case <-s.stop:
+				logger.Info("Scanner stopped")
+				return
+			case <-time.After(1 * time.Second):
+			}
 		}
 
 		// Periodic scan
@@ -55,33 +80,124 @@ func (s *Scanner) Start() {
 	}()
 }
 
+// Stop ends scanning and waits for a scan in progress, which connects to
+// no further ports. It is safe to call more than once.
 func (s *Scanner) Stop() {
-	close(s.stop)
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.stop)
+	}
+	s.mu.Unlock()
+	s.active.Wait()
+}
+
+// rescan runs one scan cycle in the background, unless stopped
+func (s *Scanner) rescan() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.active.Add(1)
+	go func() {
+		defer s.active.Done()
+		s.scanAndConnect()
+	}()
+}
+
+func (s *Scanner) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// Status returns the current scanner status
+func (s *Scanner) Status() ScannerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statusLocked()
+}
+
+func (s *Scanner) statusLocked() ScannerStatus {
+	status := ScannerStatus{
+		State:      "idle",
+		LastResult: s.lastResult,
+		LastPort:   s.lastPort,
+	}
+	if !s.lastScan.IsZero() {
+		lastScan := s.lastScan
+		status.LastScan = &lastScan
+	}
+	if s.stopped {
+		status.State = "stopped"
+	} else if s.scanning {
+		status.State = "scanning"
+	}
+	return status
 }
 
 // scanAndConnect finds and connects to a POS device
 func (s *Scanner) scanAndConnect() bool {
+	s.mu.Lock()
+	s.scanning = true
+	s.mu.Unlock()
+	s.notify()
+
+	found, port := s.scan()
+
+	result := "not_found"
+	if found {
+		result = "found"
+	}
+	metrics.ScanCycles.WithLabelValues(result).Inc()
+
+	s.mu.Lock()
+	s.scanning = false
+	s.lastScan = time.Now()
+	s.lastResult = result
+	if found {
+		s.lastPort = port
+	}
+	s.mu.Unlock()
+	s.notify()
+
+	return found
+}
+
+// notify publishes the current status
+func (s *Scanner) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Manager.Events.Publish(ScanUpdated{Status: s.statusLocked()})
+}
+
+// scan runs one scan cycle and returns the port a device was found on
+func (s *Scanner) scan() (bool, string) {
 	logger.Info("Scanning for POS device...")
 
 	ports := s.discoverPorts()
 
 	if len(ports) == 0 {
 		logger.Info("No candidate ports found")
-		return false
+		return false, ""
 	}
 
 	logger.Debug("Found %d candidate ports: %v", len(ports), ports)
 
 	for _, portName := range ports {
+		if s.isStopped() {
+			return false, ""
+		}
 		logger.Debug("Probing port: %s", portName)
 		if s.probePort(portName) {
 			logger.Info("POS device found on %s", portName)
-			return true
+			return true, portName
 		}
 	}
 
 	logger.Info("No POS device found in this scan cycle")
-	return false
+	return false, ""
 }
 
 // discoverPorts finds all candidate ports (serial + TCP mock)
@@ -207,6 +323,9 @@ func (s *Scanner) probePort(portName string) bool {
 
 	// 9. Close and reconnect via Manager
 	port.Close()
+	if s.isStopped() {
+		return false
+	}
 	return s.Manager.ConnectTo(portName)
 }
 
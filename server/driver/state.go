@@ -62,10 +62,10 @@ type StatusInfo struct {
 	TransType   string    `json:"trans_type,omitempty"`
 	Amount      string    `json:"amount,omitempty"`
 	IsConnected bool      `json:"is_connected"`
-}
 
-// StateChangeCallback is called when state changes
-type StateChangeCallback func(info StatusInfo)
+	TransactionID string `json:"transaction_id,omitempty"`
+	RequestID     string `json:"request_id,omitempty"`
+}
 
 // StateMachine manages transaction state with thread-safety
 type StateMachine struct {
@@ -76,35 +76,43 @@ type StateMachine struct {
 	lastError    string
 	transType    string
 	amount       string
+	txnID        string
+	requestID    string
 	isConnected  bool
 
-	cancelChan    chan struct{}
-	onStateChange StateChangeCallback
+	cancelChan chan struct{}
+	events     *Bus // Receives StateChanged and ConnectionChanged (nil: none)
 }
 
-// NewStateMachine creates a new state machine
-func NewStateMachine() *StateMachine {
+// NewStateMachine creates a new state machine that publishes its changes
+// on events
+func NewStateMachine(events *Bus) *StateMachine {
 	return &StateMachine{
 		currentState: StateIdle,
 		isConnected:  false,
 		cancelChan:   make(chan struct{}),
+		events:       events,
 	}
 }
 
-// SetCallback sets the state change callback
-func (sm *StateMachine) SetCallback(cb StateChangeCallback) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	sm.onStateChange = cb
-}
-
 // SetConnected sets the connection status
 func (sm *StateMachine) SetConnected(connected bool) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	if sm.isConnected == connected {
+		return
+	}
 	sm.isConnected = connected
-	if sm.onStateChange != nil {
-		sm.onStateChange(sm.getStatusInfoLocked())
+	if sm.events != nil {
+		sm.events.Publish(ConnectionChanged{Connected: connected, Status: sm.getStatusInfoLocked()})
+	}
+}
+
+// publishLocked reports a transition from the given state. Publishing under
+// the lock keeps events in transition order; subscribers run outside it.
+func (sm *StateMachine) publishLocked(from TransactionState) {
+	if sm.events != nil {
+		sm.events.Publish(StateChanged{From: from, To: sm.currentState, Status: sm.getStatusInfoLocked()})
 	}
 }
 
@@ -136,6 +144,9 @@ func (sm *StateMachine) getStatusInfoLocked() StatusInfo {
 		LastError:   sm.lastError,
 		TransType:   sm.transType,
 		Amount:      sm.amount,
+
+		TransactionID: sm.txnID,
+		RequestID:     sm.requestID,
 	}
 
 	if sm.currentState != StateIdle {
@@ -174,6 +185,7 @@ func (sm *StateMachine) TransitionTo(newState TransactionState) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
+	from := sm.currentState
 	sm.currentState = newState
 	sm.stateStarted = time.Now()
 
@@ -182,10 +194,7 @@ func (sm *StateMachine) TransitionTo(newState TransactionState) {
 		sm.lastError = ""
 	}
 
-	// Notify callback
-	if sm.onStateChange != nil {
-		sm.onStateChange(sm.getStatusInfoLocked())
-	}
+	sm.publishLocked(from)
 }
 
 // TransitionToError transitions to error state with a message
@@ -193,13 +202,12 @@ func (sm *StateMachine) TransitionToError(err string) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
+	from := sm.currentState
 	sm.currentState = StateError
 	sm.stateStarted = time.Now()
 	sm.lastError = err
 
-	if sm.onStateChange != nil {
-		sm.onStateChange(sm.getStatusInfoLocked())
-	}
+	sm.publishLocked(from)
 }
 
 // TransitionToTimeout transitions to timeout state
@@ -207,17 +215,17 @@ func (sm *StateMachine) TransitionToTimeout() {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
+	from := sm.currentState
 	sm.currentState = StateTimeout
 	sm.stateStarted = time.Now()
 	sm.lastError = "operation timed out"
 
-	if sm.onStateChange != nil {
-		sm.onStateChange(sm.getStatusInfoLocked())
-	}
+	sm.publishLocked(from)
 }
 
-// StartTransaction initializes a new transaction
-func (sm *StateMachine) StartTransaction(transType, amount string) error {
+// StartTransaction initializes a new transaction. The IDs are echoed on
+// every status update until Reset.
+func (sm *StateMachine) StartTransaction(txnID, requestID, transType, amount string) error {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -227,6 +235,8 @@ func (sm *StateMachine) StartTransaction(transType, amount string) error {
 
 	sm.transType = transType
 	sm.amount = amount
+	sm.txnID = txnID
+	sm.requestID = requestID
 	sm.lastError = ""
 	sm.cancelChan = make(chan struct{})
 
@@ -238,14 +248,15 @@ func (sm *StateMachine) Reset() {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
+	from := sm.currentState
 	sm.currentState = StateIdle
 	sm.transType = ""
 	sm.amount = ""
+	sm.txnID = ""
+	sm.requestID = ""
 	sm.stateStarted = time.Time{}
 
-	if sm.onStateChange != nil {
-		sm.onStateChange(sm.getStatusInfoLocked())
-	}
+	sm.publishLocked(from)
 }
 
 // Abort attempts to cancel the current transaction
@@ -265,13 +276,12 @@ func (sm *StateMachine) Abort() bool {
 		close(sm.cancelChan)
 	}
 
+	from := sm.currentState
 	sm.currentState = StateError
 	sm.lastError = "aborted by user"
 	sm.stateStarted = time.Now()
 
-	if sm.onStateChange != nil {
-		sm.onStateChange(sm.getStatusInfoLocked())
-	}
+	sm.publishLocked(from)
 
 	return true
 }
@@ -298,6 +308,7 @@ func (sm *StateMachine) IsTimedOut() bool {
 
 // Error definitions
 var ErrTransactionInProgress = &TransactionError{Message: "transaction already in progress"}
+var ErrNotConnected = &TransactionError{Message: "POS device not connected"}
 
 type TransactionError struct {
 	Message string
@@ -0,0 +1,270 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"ecpay-server/protocol"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Hook stages
+const (
+	StageBefore = "before"
+	StageAfter  = "after"
+)
+
+// maxOutput caps how much a hook may write back
+const maxOutput = 1 << 20
+
+// Config is a hook from the "hooks" section of the config file: a local
+// executable (Exec) or an HTTP endpoint (URL). Both receive a JSON Call and
+// may answer with a JSON Reply.
+type Config struct {
+	Name      string   `json:"name"`
+	Stage     string   `json:"stage"`                // "before" or "after"
+	Exec      []string `json:"exec,omitempty"`       // Program and arguments; the call is written to stdin
+	URL       string   `json:"url,omitempty"`        // The call is POSTed here
+	Timeout   string   `json:"timeout,omitempty"`    // Go duration, default 5s
+	OnFailure string   `json:"on_failure,omitempty"` // "reject" (default) or "allow"
+	Commands  []string `json:"commands,omitempty"`   // Transaction commands to run for; empty: all
+}
+
+// Call is the JSON sent to executable and HTTP hooks
+type Call struct {
+	Stage       string          `json:"stage"`
+	Transaction CallTransaction `json:"transaction"`
+	Result      *CallResult     `json:"result,omitempty"` // After hooks only
+}
+
+// CallTransaction is the transaction part of a Call
+type CallTransaction struct {
+	ID              string            `json:"transaction_id"`
+	RequestID       string            `json:"request_id,omitempty"`
+	Command         string            `json:"command"`
+	TransType       string            `json:"trans_type"`
+	Amount          protocol.Money    `json:"amount"`
+	OrderNo         string            `json:"order_no,omitempty"`
+	MerchantOrderID string            `json:"merchant_order_id,omitempty"`
+	Principal       string            `json:"principal,omitempty"`
+	Client          string            `json:"client"`
+	Tags            map[string]string `json:"tags,omitempty"`
+}
+
+// CallResult is the outcome part of a Call
+type CallResult struct {
+	Status   string            `json:"status"`
+	Error    string            `json:"error,omitempty"`
+	Response map[string]string `json:"response,omitempty"`
+}
+
+// Reply is the optional JSON answer of a before hook. An empty reply lets
+// the transaction through unchanged.
+type Reply struct {
+	Reject  bool              `json:"reject,omitempty"`
+	Reason  string            `json:"reason,omitempty"`
+	Amount  *protocol.Money   `json:"amount,omitempty"`   // Replaces the amount
+	OrderNo *string           `json:"order_no,omitempty"` // Replaces the order number
+	Tags    map[string]string `json:"tags,omitempty"`     // Added to the transaction
+}
+
+// caller sends a call to an external hook and returns its output
+type caller func(ctx context.Context, body []byte) ([]byte, error)
+
+// external runs a configured hook for either stage
+type external struct {
+	stage string
+	call  caller
+}
+
+// Build validates hook configurations and creates their hooks
+func Build(configs []Config) (*Set, error) {
+	s := &Set{}
+	for i, c := range configs {
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("hook %d", i+1)
+		}
+		opts := Options{Name: c.Name, OnFailure: c.OnFailure, Commands: c.Commands}
+		if c.Timeout != "" {
+			d, err := time.ParseDuration(c.Timeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("%s: invalid timeout %q", c.Name, c.Timeout)
+			}
+			opts.Timeout = d
+		}
+		switch c.OnFailure {
+		case "", FailReject, FailAllow:
+		default:
+			return nil, fmt.Errorf("%s: on_failure must be %q or %q", c.Name, FailReject, FailAllow)
+		}
+
+		var call caller
+		switch {
+		case len(c.Exec) > 0 && c.URL != "":
+			return nil, fmt.Errorf("%s: set either exec or url, not both", c.Name)
+		case len(c.Exec) > 0:
+			call = execCaller(c.Exec)
+		case c.URL != "":
+			u, err := url.Parse(c.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("%s: url %q must be an http(s) URL", c.Name, c.URL)
+			}
+			call = httpCaller(c.URL)
+		default:
+			return nil, fmt.Errorf("%s: needs exec or url", c.Name)
+		}
+
+		hook := &external{stage: c.Stage, call: call}
+		switch c.Stage {
+		case StageBefore:
+			s.before = append(s.before, beforeEntry{opts, hook})
+		case StageAfter:
+			s.after = append(s.after, afterEntry{opts, hook})
+		default:
+			return nil, fmt.Errorf("%s: stage must be %q or %q", c.Name, StageBefore, StageAfter)
+		}
+	}
+	return s, nil
+}
+
+func newCall(stage string, t Transaction) Call {
+	return Call{
+		Stage: stage,
+		Transaction: CallTransaction{
+			ID:              t.ID,
+			RequestID:       t.RequestID,
+			Command:         t.Command,
+			TransType:       t.Request.TransType,
+			Amount:          t.Request.Amount,
+			OrderNo:         t.Request.OrderNo,
+			MerchantOrderID: t.MerchantOrderID,
+			Principal:       t.Principal,
+			Client:          t.Client,
+			Tags:            t.Tags,
+		},
+	}
+}
+
+func (e *external) BeforeTransaction(ctx context.Context, t *Transaction) error {
+	body, err := json.Marshal(newCall(StageBefore, *t))
+	if err != nil {
+		return err
+	}
+	out, err := e.call(ctx, body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil
+	}
+	var reply Reply
+	if err := json.Unmarshal(out, &reply); err != nil {
+		return fmt.Errorf("invalid reply: %v", err)
+	}
+	if reply.Reject {
+		if reply.Reason == "" {
+			reply.Reason = "transaction not allowed"
+		}
+		return Reject(reply.Reason)
+	}
+	if reply.Amount != nil {
+		t.Request.Amount = *reply.Amount
+	}
+	if reply.OrderNo != nil {
+		t.Request.OrderNo = *reply.OrderNo
+	}
+	for k, v := range reply.Tags {
+		t.Tag(k, v)
+	}
+	return nil
+}
+
+func (e *external) AfterTransaction(ctx context.Context, t Transaction, r Result) error {
+	call := newCall(StageAfter, t)
+	call.Result = &CallResult{Status: r.Status, Error: r.Error, Response: r.Response}
+	body, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	_, err = e.call(ctx, body)
+	return err
+}
+
+// execCaller runs a program with the call on stdin. It must exit 0; its
+// stdout is the reply.
+func execCaller(argv []string) caller {
+	return func(ctx context.Context, body []byte) ([]byte, error) {
+		cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+		cmd.Stdin = bytes.NewReader(body)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &limitedWriter{w: &stdout, n: maxOutput}
+		cmd.Stderr = &limitedWriter{w: &stderr, n: 4096}
+		// Don't wait for grandchildren holding the pipes after a timeout
+		cmd.WaitDelay = time.Second
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("timed out")
+			}
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, fmt.Errorf("%v: %s", err, msg)
+			}
+			return nil, err
+		}
+		return stdout.Bytes(), nil
+	}
+}
+
+// hookClient has no timeout of its own: each call is bounded by the hook's
+var hookClient = &http.Client{}
+
+// httpCaller POSTs the call to url. Any 2xx status is success; the
+// response body is the reply.
+func httpCaller(url string) caller {
+	return func(ctx context.Context, body []byte) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := hookClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("timed out")
+			}
+			return nil, err
+		}
+		defer resp.Body.Close()
+		out, err := io.ReadAll(io.LimitReader(resp.Body, maxOutput))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		return out, nil
+	}
+}
+
+// limitedWriter keeps the first n bytes written and discards the rest
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	if len(p) > l.n {
+		p = p[:l.n]
+	}
+	l.n -= len(p)
+	if _, err := l.w.Write(p); err != nil {
+		return 0, err
+	}
+	return written, nil
+}
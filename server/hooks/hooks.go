@@ -0,0 +1,261 @@
+package hooks
+
+import (
+	"context"
+	"ecpay-server/logger"
+	"ecpay-server/protocol"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is how long a hook may run when no timeout is configured
+const DefaultTimeout = 5 * time.Second
+
+// Failure policies: what happens to a transaction when a before hook fails
+// (errors, times out or returns garbage) rather than rejecting it
+const (
+	FailReject = "reject" // Refuse the transaction (default)
+	FailAllow  = "allow"  // Log the failure and continue without the hook
+)
+
+// Transaction describes a transaction to hooks. Before hooks may change
+// Request and add Tags; the tags are stored with the transaction and sent
+// with its events.
+type Transaction struct {
+	ID              string
+	RequestID       string
+	Command         string // "SALE", "REFUND", "VOID", "SETTLEMENT", "ECHO"
+	Principal       string // Authenticated client identity
+	Client          string // Remote address
+	MerchantOrderID string
+	Request         protocol.ECPayRequest
+	Tags            map[string]string
+}
+
+// Tag sets a tag, creating the map if needed
+func (t *Transaction) Tag(key, value string) {
+	if t.Tags == nil {
+		t.Tags = make(map[string]string)
+	}
+	t.Tags[key] = value
+}
+
+// Result is the outcome of a transaction passed to after hooks
+type Result struct {
+	Status   string            // "APPROVED", "DECLINED", "FAILED", "UNKNOWN"
+	Error    string            // Set unless approved
+	Response map[string]string // Parsed terminal response, if any
+}
+
+// BeforeHook runs before a transaction is sent to the terminal. Returning
+// Reject vetoes the transaction; any other error is a hook failure handled
+// by the hook's failure policy.
+type BeforeHook interface {
+	BeforeTransaction(ctx context.Context, t *Transaction) error
+}
+
+// AfterHook runs once a transaction has finished. Errors are logged.
+type AfterHook interface {
+	AfterTransaction(ctx context.Context, t Transaction, r Result) error
+}
+
+// BeforeFunc adapts a function to BeforeHook
+type BeforeFunc func(ctx context.Context, t *Transaction) error
+
+func (f BeforeFunc) BeforeTransaction(ctx context.Context, t *Transaction) error {
+	return f(ctx, t)
+}
+
+// AfterFunc adapts a function to AfterHook
+type AfterFunc func(ctx context.Context, t Transaction, r Result) error
+
+func (f AfterFunc) AfterTransaction(ctx context.Context, t Transaction, r Result) error {
+	return f(ctx, t, r)
+}
+
+// RejectedError is returned by RunBefore when a hook vetoed the transaction
+// or failed under the reject policy
+type RejectedError struct {
+	Hook   string
+	Reason string
+	Failed bool // The hook failed rather than rejecting
+}
+
+func (e *RejectedError) Error() string {
+	if e.Failed {
+		return fmt.Sprintf("transaction hook %s failed: %s", e.Hook, e.Reason)
+	}
+	return fmt.Sprintf("rejected by %s: %s", e.Hook, e.Reason)
+}
+
+// Reject returns the error a before hook uses to veto a transaction
+func Reject(reason string) error {
+	return &RejectedError{Reason: reason}
+}
+
+// Options controls how a hook is run
+type Options struct {
+	Name      string        // Shown in logs and rejections
+	Timeout   time.Duration // Zero: DefaultTimeout
+	OnFailure string        // FailReject (default) or FailAllow; before hooks only
+	Commands  []string      // Transaction commands the hook runs for; empty: all
+}
+
+func (o Options) timeout() time.Duration {
+	if o.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return o.Timeout
+}
+
+func (o Options) appliesTo(command string) bool {
+	if len(o.Commands) == 0 {
+		return true
+	}
+	for _, c := range o.Commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+type beforeEntry struct {
+	opts Options
+	hook BeforeHook
+}
+
+type afterEntry struct {
+	opts Options
+	hook AfterHook
+}
+
+// Set is a list of hooks built from configuration
+type Set struct {
+	before []beforeEntry
+	after  []afterEntry
+}
+
+// Len returns the number of hooks in the set
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.before) + len(s.after)
+}
+
+// Registry holds the hooks run around transactions: hooks registered in
+// code, which stay for the life of the process, followed by the configured
+// set, which a reload replaces. Hooks run in registration order.
+type Registry struct {
+	mu         sync.RWMutex
+	registered Set
+	configured *Set
+}
+
+// New creates an empty registry
+func New() *Registry {
+	return &Registry{}
+}
+
+// Before registers a hook run before transactions
+func (r *Registry) Before(opts Options, h BeforeHook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered.before = append(r.registered.before, beforeEntry{opts, h})
+}
+
+// After registers a hook run after transactions
+func (r *Registry) After(opts Options, h AfterHook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered.after = append(r.registered.after, afterEntry{opts, h})
+}
+
+// Configure replaces the configured hooks. nil removes them.
+func (r *Registry) Configure(s *Set) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configured = s
+}
+
+// hooks returns the hooks to run, registered first
+func (r *Registry) hooks() ([]beforeEntry, []afterEntry) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	before := append([]beforeEntry(nil), r.registered.before...)
+	after := append([]afterEntry(nil), r.registered.after...)
+	if r.configured != nil {
+		before = append(before, r.configured.before...)
+		after = append(after, r.configured.after...)
+	}
+	return before, after
+}
+
+// RunBefore runs the before hooks for t in order. It returns a
+// *RejectedError if a hook vetoed the transaction or failed under the
+// reject policy; later hooks are then skipped.
+func (r *Registry) RunBefore(ctx context.Context, t *Transaction) error {
+	before, _ := r.hooks()
+	for _, e := range before {
+		if !e.opts.appliesTo(t.Command) {
+			continue
+		}
+		// The hook works on a copy, which an abandoned hook keeps
+		working := *t
+		working.Tags = maps.Clone(t.Tags)
+		err := call(ctx, e.opts.timeout(), func(ctx context.Context) error {
+			return e.hook.BeforeTransaction(ctx, &working)
+		})
+		if err == nil {
+			*t = working
+			continue
+		}
+
+		var rejected *RejectedError
+		if errors.As(err, &rejected) && !rejected.Failed {
+			return &RejectedError{Hook: e.opts.Name, Reason: rejected.Reason}
+		}
+		if e.opts.OnFailure == FailAllow {
+			logger.Warn("Transaction hook %s failed for %s, continuing without it: %v", e.opts.Name, t.ID, err)
+			continue
+		}
+		return &RejectedError{Hook: e.opts.Name, Reason: err.Error(), Failed: true}
+	}
+	return nil
+}
+
+// RunAfter runs the after hooks for t in order, logging failures
+func (r *Registry) RunAfter(ctx context.Context, t Transaction, res Result) {
+	_, after := r.hooks()
+	for _, e := range after {
+		if !e.opts.appliesTo(t.Command) {
+			continue
+		}
+		err := call(ctx, e.opts.timeout(), func(ctx context.Context) error {
+			return e.hook.AfterTransaction(ctx, t, res)
+		})
+		if err != nil {
+			logger.Warn("Transaction hook %s failed for %s: %v", e.opts.Name, t.ID, err)
+		}
+	}
+}
+
+// call runs a hook on its own goroutine with a timeout. A hook that ignores
+// its context is abandoned when the timeout expires, so it cannot hold up
+// the transaction.
+func call(ctx context.Context, timeout time.Duration, hook func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- hook(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
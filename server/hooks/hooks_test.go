@@ -0,0 +1,66 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStuckHookIsAbandoned(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	r := New()
+	r.Before(Options{Name: "stuck", Timeout: 20 * time.Millisecond}, BeforeFunc(func(_ context.Context, t *Transaction) error {
+		<-release // Ignores its context
+		t.Request.Amount = 1
+		return nil
+	}))
+	r.After(Options{Name: "stuck", Timeout: 20 * time.Millisecond}, AfterFunc(func(context.Context, Transaction, Result) error {
+		<-release
+		return nil
+	}))
+
+	txn := &Transaction{ID: "t1", Command: "SALE"}
+	txn.Request.Amount = 10000
+	done := make(chan error, 1)
+	go func() { done <- r.RunBefore(context.Background(), txn) }()
+	select {
+	case err := <-done:
+		var rejected *RejectedError
+		if !errors.As(err, &rejected) || !rejected.Failed || rejected.Hook != "stuck" {
+			t.Fatalf("RunBefore = %v, want a failure of hook stuck", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("RunBefore waited for a hook that ignores its context")
+	}
+	if txn.Request.Amount != 10000 {
+		t.Fatalf("abandoned hook changed the amount to %s", txn.Request.Amount)
+	}
+
+	go func() {
+		r.RunAfter(context.Background(), *txn, Result{Status: "APPROVED"})
+		done <- nil
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("RunAfter waited for a hook that ignores its context")
+	}
+}
+
+func TestFailedAllowHookChangesNothing(t *testing.T) {
+	r := New()
+	r.Before(Options{Name: "broken", OnFailure: FailAllow}, BeforeFunc(func(_ context.Context, t *Transaction) error {
+		t.Tag("lane", "3")
+		return errors.New("backend down")
+	}))
+	txn := &Transaction{ID: "t1", Command: "SALE"}
+	if err := r.RunBefore(context.Background(), txn); err != nil {
+		t.Fatalf("RunBefore = %v, want nil under the allow policy", err)
+	}
+	if len(txn.Tags) != 0 {
+		t.Fatalf("failed hook left tags %v", txn.Tags)
+	}
+}
@@ -0,0 +1,279 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Phases recorded in the journal. In-flight phases mirror the driver's
+// transaction states; the rest mark how a transaction ended.
+const (
+	PhaseSending      = "SENDING"
+	PhaseWaitACK      = "WAIT_ACK"
+	PhaseWaitResponse = "WAIT_RESPONSE"
+	PhaseParsing      = "PARSING"
+	PhaseSuccess      = "SUCCESS"
+	PhaseError        = "ERROR"
+	PhaseUnknown      = "UNKNOWN"     // Sent, no result (timeout/abort)
+	PhaseInterrupted  = "INTERRUPTED" // Found in-flight on startup
+	PhaseReconciled   = "RECONCILED"  // UNKNOWN resolved by a late response
+	PhaseResolved     = "RESOLVED"    // UNKNOWN or INTERRUPTED resolved by an operator
+)
+
+// compactThreshold is the file size above which resolved entries are dropped
+const compactThreshold = 1024 * 1024 // 1MB
+
+// Entry is the journal record of one transaction. Every phase change appends
+// the full entry, so the last line for an ID is its current state.
+type Entry struct {
+	ID        string    `json:"id"`
+	RequestID string    `json:"request_id,omitempty"`
+	Phase     string    `json:"phase"`
+	TransType string    `json:"trans_type"`
+	HostID    string    `json:"host_id,omitempty"`
+	Amount    string    `json:"amount,omitempty"`
+	OrderNo   string    `json:"order_no,omitempty"`
+	PosTime   string    `json:"pos_time,omitempty"`
+	FrameHash string    `json:"frame_hash,omitempty"`
+	Outcome   string    `json:"outcome,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Note      string    `json:"note,omitempty"` // Who resolved it manually, and why
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// InFlight reports whether the entry was left mid-transaction
+func (e Entry) InFlight() bool {
+	switch e.Phase {
+	case PhaseSending, PhaseWaitACK, PhaseWaitResponse, PhaseParsing:
+		return true
+	}
+	return false
+}
+
+// Unresolved reports whether the transaction still needs reconciliation
+func (e Entry) Unresolved() bool {
+	return e.InFlight() || e.Phase == PhaseUnknown || e.Phase == PhaseInterrupted
+}
+
+// Journal is an append-only, fsynced write-ahead log of transactions
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	entries map[string]*Entry
+}
+
+// Open opens (or creates) the journal at path and recovers its state.
+// Entries left in an in-flight phase are rewritten as INTERRUPTED and
+// returned together with earlier unresolved entries, oldest first.
+func Open(path string) (*Journal, []Entry, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create journal directory: %v", err)
+	}
+
+	j := &Journal{
+		path:    path,
+		entries: make(map[string]*Entry),
+	}
+	if err := j.load(); err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	var unresolved []Entry
+	for _, e := range j.entries {
+		if e.InFlight() {
+			e.Error = "interrupted in phase " + e.Phase
+			e.Phase = PhaseInterrupted
+			e.UpdatedAt = now
+		}
+		if e.Unresolved() {
+			unresolved = append(unresolved, *e)
+		}
+	}
+	sort.Slice(unresolved, func(i, k int) bool {
+		return unresolved[i].CreatedAt.Before(unresolved[k].CreatedAt)
+	})
+
+	// Rewrite with only unresolved entries so recovery state is durable
+	if err := j.compactLocked(); err != nil {
+		return nil, nil, err
+	}
+	return j, unresolved, nil
+}
+
+// load replays the journal file into memory
+func (j *Journal) load() error {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A torn final line from a crash mid-write is expected
+			continue
+		}
+		j.entries[e.ID] = &e
+	}
+	return scanner.Err()
+}
+
+// Begin records a new transaction. It returns only after the entry is on
+// disk, so callers must not send the frame if it fails.
+func (j *Journal) Begin(e Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	e.CreatedAt = now
+	e.UpdatedAt = now
+	if e.Phase == "" {
+		e.Phase = PhaseSending
+	}
+	j.entries[e.ID] = &e
+	return j.appendLocked(e)
+}
+
+// Update records a phase change for a transaction
+func (j *Journal) Update(id, phase, errMsg string) error {
+	return j.update(id, func(e *Entry) {
+		e.Phase = phase
+		e.Error = errMsg
+	})
+}
+
+// Resolve records the real outcome of an unresolved transaction
+func (j *Journal) Resolve(id, outcome string) error {
+	return j.update(id, func(e *Entry) {
+		e.Phase = PhaseReconciled
+		e.Outcome = outcome
+	})
+}
+
+// ResolveManually records the outcome an operator determined for an
+// unresolved transaction, e.g. from the terminal's own report
+func (j *Journal) ResolveManually(id, outcome, note string) error {
+	return j.update(id, func(e *Entry) {
+		e.Phase = PhaseResolved
+		e.Outcome = outcome
+		e.Note = note
+	})
+}
+
+func (j *Journal) update(id string, apply func(e *Entry)) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e, ok := j.entries[id]
+	if !ok {
+		return fmt.Errorf("journal entry %s not found", id)
+	}
+	apply(e)
+	e.UpdatedAt = time.Now()
+	if err := j.appendLocked(*e); err != nil {
+		return err
+	}
+
+	if !e.Unresolved() {
+		delete(j.entries, id)
+		if j.size > compactThreshold {
+			return j.compactLocked()
+		}
+	}
+	return nil
+}
+
+// appendLocked writes one entry and fsyncs (must hold lock)
+func (j *Journal) appendLocked(e Entry) error {
+	if j.file == nil {
+		return fmt.Errorf("journal is closed")
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("journal write failed: %v", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("journal sync failed: %v", err)
+	}
+	return nil
+}
+
+// compactLocked rewrites the journal keeping only unresolved entries
+// (must hold lock)
+func (j *Journal) compactLocked() error {
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create journal: %v", err)
+	}
+
+	var size int64
+	for id, e := range j.entries {
+		if !e.Unresolved() {
+			delete(j.entries, id)
+			continue
+		}
+		line, _ := json.Marshal(e)
+		n, err := tmp.Write(append(line, '\n'))
+		size += int64(n)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write journal: %v", err)
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync journal: %v", err)
+	}
+	tmp.Close()
+
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		return fmt.Errorf("failed to replace journal: %v", err)
+	}
+
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %v", err)
+	}
+	j.file = file
+	j.size = size
+	return nil
+}
+
+// Close closes the journal file
+func (j *Journal) Close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+}
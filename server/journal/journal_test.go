@@ -0,0 +1,66 @@
+package journal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func ids(entries []Entry) []string {
+	var list []string
+	for _, e := range entries {
+		list = append(list, e.ID+":"+e.Phase)
+	}
+	return list
+}
+
+func TestUnresolvedReportedUntilResolved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, unresolved, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unresolved) != 0 {
+		t.Fatalf("new journal reports %v", ids(unresolved))
+	}
+	j.Begin(Entry{ID: "unknown", TransType: "01", Amount: "100.00"})
+	j.Update("unknown", PhaseUnknown, "timeout")
+	j.Begin(Entry{ID: "inflight", TransType: "01", Amount: "200.00"})
+	j.Update("inflight", PhaseWaitResponse, "")
+	j.Begin(Entry{ID: "done", TransType: "01", Amount: "300.00"})
+	j.Update("done", PhaseSuccess, "")
+	j.Close()
+
+	// Every start reports them again while nobody resolves them
+	for start := 1; start <= 2; start++ {
+		j, unresolved, err = Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ids(unresolved)
+		if len(got) != 2 || got[0] != "unknown:"+PhaseUnknown || got[1] != "inflight:"+PhaseInterrupted {
+			t.Fatalf("start %d reports %v, want the unknown and the interrupted transaction", start, got)
+		}
+		j.Close()
+	}
+
+	j, _, _ = Open(path)
+	if err := j.ResolveManually("unknown", "DECLINED", "resolved by sup: not on terminal report"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.ResolveManually("inflight", "APPROVED", "resolved by sup"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.ResolveManually("done", "APPROVED", ""); err == nil {
+		t.Fatal("resolved a finished transaction")
+	}
+	j.Close()
+
+	j, unresolved, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if len(unresolved) != 0 {
+		t.Fatalf("resolved transactions reported again: %v", ids(unresolved))
+	}
+}
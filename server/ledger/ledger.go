@@ -0,0 +1,402 @@
+package ledger
+
+import (
+	"bytes"
+	"ecpay-server/protocol"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Transaction status values stored in the ledger
+const (
+	StatusPending  = "PENDING"  // Sent to the POS, no result yet
+	StatusApproved = "APPROVED" // RespCode 0000
+	StatusDeclined = "DECLINED" // POS answered with a non-zero RespCode
+	StatusFailed   = "FAILED"   // Not sent, NAK, write or checksum error
+	StatusUnknown  = "UNKNOWN"  // Sent, result never received
+)
+
+var (
+	bucketTransactions = []byte("transactions")
+	bucketOrders       = []byte("orders")          // EC order number -> transaction ID
+	bucketRefunds      = []byte("refunds")         // "<original EC order number>/<refund ID>" -> nil
+	bucketMerchant     = []byte("merchant_orders") // "<merchant order ID>/<sale ID>" -> nil
+	bucketIdempotency  = []byte("idempotency")     // Idempotency key -> transaction ID
+)
+
+// Record is one transaction as stored in the ledger
+type Record struct {
+	ID         string            `json:"transaction_id"`
+	RequestID  string            `json:"request_id,omitempty"` // Client correlation ID
+	IdemKey    string            `json:"idempotency_key,omitempty"`
+	Command    string            `json:"command"`
+	TransType  string            `json:"trans_type"`
+	Amount     string            `json:"amount"`                      // Decimal NT$, e.g. "1250.00"
+	OrderNo    string            `json:"order_no,omitempty"`          // Order number sent in the request (refund reference)
+	ECOrderNo  string            `json:"ec_order_no,omitempty"`       // Order number assigned by the POS
+	MerchantID string            `json:"merchant_order_id,omitempty"` // POS software's own order reference
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	Response   map[string]string `json:"response,omitempty"`
+	Client     string            `json:"client"`
+	Principal  string            `json:"principal,omitempty"`   // Authenticated client identity
+	ApprovedBy string            `json:"approved_by,omitempty"` // Supervisor who approved a large refund
+	Tags       map[string]string `json:"tags,omitempty"`        // Added by transaction hooks, e.g. a lane number
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at,omitempty"`
+	DurationMs int64             `json:"duration_ms"`
+}
+
+// Filter selects records in Query. Zero values match everything.
+type Filter struct {
+	From      time.Time
+	To        time.Time
+	Type      string // Command name ("SALE") or TransType code ("01")
+	Status    string
+	OrderNo   string // Matches request, EC or merchant order number
+	MinAmount protocol.Money
+	MaxAmount protocol.Money
+	Limit     int
+}
+
+// DefaultQueryLimit caps Query results when no limit is given
+const DefaultQueryLimit = 100
+
+// Ledger is an embedded transaction history store backed by bbolt
+type Ledger struct {
+	db *bolt.DB
+}
+
+// Open opens (or creates) the ledger database at path
+func Open(path string) (*Ledger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create ledger directory: %v", err)
+	}
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketTransactions, bucketOrders, bucketRefunds, bucketMerchant, bucketIdempotency} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize ledger: %v", err)
+	}
+
+	return &Ledger{db: db}, nil
+}
+
+// Close closes the database
+func (l *Ledger) Close() error {
+	return l.db.Close()
+}
+
+// Put inserts or replaces a record
+func (l *Ledger) Put(rec Record) error {
+	if rec.ID == "" {
+		return fmt.Errorf("record has no transaction ID")
+	}
+	if !rec.FinishedAt.IsZero() {
+		rec.DurationMs = rec.FinishedAt.Sub(rec.StartedAt).Milliseconds()
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return l.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketTransactions).Put([]byte(rec.ID), data); err != nil {
+			return err
+		}
+		if rec.ECOrderNo != "" && !isReversal(rec.Command) {
+			if err := tx.Bucket(bucketOrders).Put([]byte(rec.ECOrderNo), []byte(rec.ID)); err != nil {
+				return err
+			}
+		}
+		if rec.IdemKey != "" {
+			if err := tx.Bucket(bucketIdempotency).Put([]byte(rec.IdemKey), []byte(rec.ID)); err != nil {
+				return err
+			}
+		}
+		if isReversal(rec.Command) && rec.OrderNo != "" {
+			if err := tx.Bucket(bucketRefunds).Put([]byte(rec.OrderNo+"/"+rec.ID), nil); err != nil {
+				return err
+			}
+		}
+		if rec.Command == "SALE" && rec.MerchantID != "" {
+			return tx.Bucket(bucketMerchant).Put([]byte(rec.MerchantID+"/"+rec.ID), nil)
+		}
+		return nil
+	})
+}
+
+// Get returns the record with the given transaction ID
+func (l *Ledger) Get(id string) (Record, bool, error) {
+	var rec Record
+	var found bool
+
+	err := l.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketTransactions).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &rec)
+	})
+	return rec, found, err
+}
+
+// GetByECOrderNo returns the transaction that was assigned the given EC
+// order number by the POS
+func (l *Ledger) GetByECOrderNo(orderNo string) (Record, bool, error) {
+	var id []byte
+	l.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucketOrders).Get([]byte(orderNo)); v != nil {
+			id = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if id == nil {
+		return Record{}, false, nil
+	}
+	return l.Get(string(id))
+}
+
+// GetByIdempotencyKey returns the latest transaction submitted with the
+// given idempotency key
+func (l *Ledger) GetByIdempotencyKey(key string) (Record, bool, error) {
+	var id []byte
+	l.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucketIdempotency).Get([]byte(key)); v != nil {
+			id = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if id == nil {
+		return Record{}, false, nil
+	}
+	return l.Get(string(id))
+}
+
+// ReleaseIdempotencyKey forgets key if it still points at id, so that a
+// request which never reached the terminal can be retried
+func (l *Ledger) ReleaseIdempotencyKey(key, id string) error {
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketIdempotency)
+		if string(b.Get([]byte(key))) != id {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+// isReversal reports whether a command refers back to an original sale
+func isReversal(command string) bool {
+	return command == "REFUND" || command == "VOID"
+}
+
+// FindSaleByMerchantID returns the sale recorded under a merchant order
+// reference, preferring the newest approved one
+func (l *Ledger) FindSaleByMerchantID(merchantID string) (Record, bool, error) {
+	var sale Record
+	var found bool
+
+	err := l.db.View(func(tx *bolt.Tx) error {
+		txns := tx.Bucket(bucketTransactions)
+		prefix := []byte(merchantID + "/")
+		c := tx.Bucket(bucketMerchant).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			data := txns.Get(k[len(prefix):])
+			if data == nil {
+				continue
+			}
+			var rec Record
+			if err := json.Unmarshal(data, &rec); err != nil {
+				continue
+			}
+			// Keys are in time order: later approved sales win
+			if !found || rec.Status == StatusApproved || sale.Status != StatusApproved {
+				sale = rec
+				found = true
+			}
+		}
+		return nil
+	})
+	return sale, found, err
+}
+
+// RefundsFor returns all refund and void records referencing the given
+// original EC order number, oldest first
+func (l *Ledger) RefundsFor(orderNo string) ([]Record, error) {
+	var refunds []Record
+	err := l.db.View(func(tx *bolt.Tx) error {
+		txns := tx.Bucket(bucketTransactions)
+		prefix := []byte(orderNo + "/")
+		c := tx.Bucket(bucketRefunds).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			data := txns.Get(k[len(prefix):])
+			if data == nil {
+				continue
+			}
+			var rec Record
+			if err := json.Unmarshal(data, &rec); err != nil {
+				continue
+			}
+			refunds = append(refunds, rec)
+		}
+		return nil
+	})
+	return refunds, err
+}
+
+// Total adds up the amounts of command transactions started since from that
+// may have reached the card (approved, pending or unknown). If principal is
+// set only that principal's transactions count.
+func (l *Ledger) Total(from time.Time, command, principal string) (protocol.Money, error) {
+	var total protocol.Money
+	err := l.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketTransactions).Cursor()
+		// Keys are in time order: walk back until before from
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var rec Record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				continue
+			}
+			if rec.StartedAt.Before(from) {
+				break
+			}
+			if rec.Command != command || (principal != "" && rec.Principal != principal) {
+				continue
+			}
+			switch rec.Status {
+			case StatusApproved, StatusPending, StatusUnknown:
+				amount, _ := protocol.ParseAmount(rec.Amount)
+				total += amount
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+// Finish records the result of a pending transaction
+func (l *Ledger) Finish(id, status, errMsg string, response map[string]string) error {
+	return l.update(id, func(rec *Record) {
+		rec.Status = status
+		rec.Error = errMsg
+		if response != nil {
+			rec.Response = response
+			if orderNo := response["OrderNo"]; orderNo != "" {
+				rec.ECOrderNo = orderNo
+			}
+		}
+		rec.FinishedAt = time.Now()
+	})
+}
+
+// MarkUnknown marks a pending transaction as UNKNOWN, e.g. after it was
+// found interrupted on startup. Records already finished are left alone.
+func (l *Ledger) MarkUnknown(id, reason string) error {
+	return l.update(id, func(rec *Record) {
+		if rec.Status != StatusPending {
+			return
+		}
+		rec.Status = StatusUnknown
+		rec.Error = reason
+	})
+}
+
+func (l *Ledger) update(id string, apply func(rec *Record)) error {
+	rec, found, err := l.Get(id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("transaction %s not found in ledger", id)
+	}
+	apply(&rec)
+	return l.Put(rec)
+}
+
+// Query returns records matching the filter, newest first
+func (l *Ledger) Query(f Filter) ([]Record, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+
+	records := []Record{}
+	err := l.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketTransactions).Cursor()
+
+		// Transaction IDs start with YYYYMMDDHHMMSS, so keys are in time order
+		var k, v []byte
+		if f.To.IsZero() {
+			k, v = c.Last()
+		} else {
+			upper := []byte(f.To.Local().Format("20060102150405") + "\xff")
+			k, v = c.Seek(upper)
+			if k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+
+		for ; k != nil && len(records) < limit; k, v = c.Prev() {
+			var rec Record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				continue
+			}
+			if !f.From.IsZero() && rec.StartedAt.Before(f.From) {
+				break
+			}
+			if f.matches(rec) {
+				records = append(records, rec)
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// matches checks all filter fields except the time range lower bound
+func (f Filter) matches(rec Record) bool {
+	if !f.To.IsZero() && rec.StartedAt.After(f.To) {
+		return false
+	}
+	if f.Type != "" && !strings.EqualFold(f.Type, rec.Command) && f.Type != rec.TransType {
+		return false
+	}
+	if f.Status != "" && !strings.EqualFold(f.Status, rec.Status) {
+		return false
+	}
+	if f.OrderNo != "" && f.OrderNo != rec.OrderNo && f.OrderNo != rec.ECOrderNo && f.OrderNo != rec.MerchantID {
+		return false
+	}
+	if f.MinAmount > 0 || f.MaxAmount > 0 {
+		amount, _ := protocol.ParseAmount(rec.Amount)
+		if f.MinAmount > 0 && amount < f.MinAmount {
+			return false
+		}
+		if f.MaxAmount > 0 && amount > f.MaxAmount {
+			return false
+		}
+	}
+	return true
+}
@@ -235,26 +235,33 @@ func Close() {
 
 // Info logs an info message
 func Info(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	log.Printf("[INFO] %s", msg)
+	emit("INFO", Txn{}, fmt.Sprintf(format, args...))
 }
 
 // Error logs an error message
 func Error(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	log.Printf("[ERROR] %s", msg)
+	emit("ERROR", Txn{}, fmt.Sprintf(format, args...))
 }
 
 // Debug logs a debug message
 func Debug(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	log.Printf("[DEBUG] %s", msg)
+	emit("DEBUG", Txn{}, fmt.Sprintf(format, args...))
 }
 
 // Warn logs a warning message
 func Warn(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	log.Printf("[WARN] %s", msg)
+	emit("WARN", Txn{}, fmt.Sprintf(format, args...))
+}
+
+// Audit logs a security-relevant event (denied commands, approvals)
+func Audit(format string, args ...interface{}) {
+	emit("AUDIT", Txn{}, fmt.Sprintf(format, args...))
+}
+
+// emit writes a log line and hands it to live subscribers
+func emit(level string, t Txn, msg string) {
+	log.Printf("[%s] %s%s", level, t.prefix(), msg)
+	publish(level, msg, t)
 }
 
 // Transaction logs a transaction event
@@ -270,3 +277,43 @@ func Protocol(direction, event string, data []byte) {
 		log.Printf("[PROTO] %s %s data=%x", direction, event, data)
 	}
 }
+
+// Txn tags log lines with the IDs of the transaction they belong to
+type Txn struct {
+	ID        string // Server-generated transaction ID
+	RequestID string // Client-supplied request ID (may be empty)
+}
+
+// prefix is the ID tag written before the message; IDs are formatted as
+// arguments rather than spliced into a format since request IDs come from
+// clients
+func (t Txn) prefix() string {
+	switch {
+	case t.ID == "":
+		return ""
+	case t.RequestID != "":
+		return fmt.Sprintf("[txn=%s req=%s] ", t.ID, t.RequestID)
+	default:
+		return fmt.Sprintf("[txn=%s] ", t.ID)
+	}
+}
+
+// Info logs an info message for the transaction
+func (t Txn) Info(format string, args ...interface{}) {
+	emit("INFO", t, fmt.Sprintf(format, args...))
+}
+
+// Error logs an error message for the transaction
+func (t Txn) Error(format string, args ...interface{}) {
+	emit("ERROR", t, fmt.Sprintf(format, args...))
+}
+
+// Debug logs a debug message for the transaction
+func (t Txn) Debug(format string, args ...interface{}) {
+	emit("DEBUG", t, fmt.Sprintf(format, args...))
+}
+
+// Warn logs a warning message for the transaction
+func (t Txn) Warn(format string, args ...interface{}) {
+	emit("WARN", t, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,150 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// BacklogSize is how many recent records are kept for replay
+	BacklogSize = 500
+
+	// subscriberBuffer is how many records a slow subscriber may fall
+	// behind before records are dropped for it
+	subscriberBuffer = 256
+)
+
+// Record is one log line as delivered to subscribers
+type Record struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"` // "DEBUG", "INFO", "WARN", "ERROR", "AUDIT"
+	Message   string    `json:"message"`
+	TxnID     string    `json:"transaction_id,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// levelRank orders levels for filtering; AUDIT ranks with WARN
+var levelRank = map[string]int{
+	"DEBUG": 0,
+	"INFO":  1,
+	"WARN":  2,
+	"AUDIT": 2,
+	"ERROR": 3,
+}
+
+// Filter selects records for a subscriber. Zero values match everything.
+type Filter struct {
+	Level string // Minimum level, e.g. "WARN" (includes AUDIT and ERROR)
+	TxnID string // Only records tagged with this transaction ID
+}
+
+// ValidLevel reports whether level can be used in a Filter
+func ValidLevel(level string) bool {
+	_, ok := levelRank[strings.ToUpper(level)]
+	return level == "" || ok
+}
+
+func (f Filter) matches(rec Record) bool {
+	if f.Level != "" && levelRank[rec.Level] < levelRank[strings.ToUpper(f.Level)] {
+		return false
+	}
+	return f.TxnID == "" || f.TxnID == rec.TxnID
+}
+
+// Subscription receives log records as they are written
+type Subscription struct {
+	C <-chan Record
+
+	ch      chan Record
+	filter  Filter
+	dropped atomic.Uint64
+}
+
+// Dropped returns how many records were skipped because the subscriber fell
+// behind
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+var stream = struct {
+	mu      sync.Mutex
+	seq     uint64
+	backlog []Record // Ring buffer of the last BacklogSize records
+	next    int
+	subs    map[*Subscription]struct{}
+}{subs: make(map[*Subscription]struct{})}
+
+// Subscribe starts delivering records matching filter. It returns up to
+// backlog recent matching records, oldest first, for replay; records after
+// them arrive on C. Call Unsubscribe when done.
+func Subscribe(filter Filter, backlog int) (*Subscription, []Record) {
+	ch := make(chan Record, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, filter: filter}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	var replay []Record
+	n := len(stream.backlog)
+	for i := 0; i < n && len(replay) < backlog; i++ {
+		// Walk newest to oldest
+		rec := stream.backlog[(stream.next-1-i+n)%n]
+		if filter.matches(rec) {
+			replay = append(replay, rec)
+		}
+	}
+	for i, j := 0, len(replay)-1; i < j; i, j = i+1, j-1 {
+		replay[i], replay[j] = replay[j], replay[i]
+	}
+
+	stream.subs[sub] = struct{}{}
+	return sub, replay
+}
+
+// Unsubscribe stops delivery and closes C
+func (s *Subscription) Unsubscribe() {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if _, ok := stream.subs[s]; ok {
+		delete(stream.subs, s)
+		close(s.ch)
+	}
+}
+
+// publish hands a record to the backlog and every matching subscriber.
+// Subscribers that are behind lose the record instead of blocking the caller.
+func publish(level, message string, t Txn) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	stream.seq++
+	rec := Record{
+		Seq:       stream.seq,
+		Time:      time.Now(),
+		Level:     level,
+		Message:   message,
+		TxnID:     t.ID,
+		RequestID: t.RequestID,
+	}
+
+	if len(stream.backlog) < BacklogSize {
+		stream.backlog = append(stream.backlog, rec)
+	} else {
+		stream.backlog[stream.next] = rec
+	}
+	stream.next = (stream.next + 1) % BacklogSize
+
+	for sub := range stream.subs {
+		if !sub.filter.matches(rec) {
+			continue
+		}
+		select {
+		case sub.ch <- rec:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
@@ -1,14 +1,30 @@
 package main
 
 import (
+	"context"
 	"ecpay-server/api"
+	"ecpay-server/auth"
+	"ecpay-server/certs"
 	"ecpay-server/config"
 	"ecpay-server/driver"
+	"ecpay-server/hooks"
+	"ecpay-server/journal"
+	"ecpay-server/ledger"
 	"ecpay-server/logger"
+	"ecpay-server/webhook"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -22,25 +38,283 @@ func main() {
 	}
 	defer logger.Close()
 
-	logger.Info("ECPay POS Server starting...")
-	fmt.Println("ECPay POS Server starting...")
+	logger.Info("ECPay POS Server %s starting (protocol v%d)...", api.ServerVersion, api.ProtocolVersion)
+	fmt.Printf("ECPay POS Server %s starting...\n", api.ServerVersion)
 	fmt.Println("Serial port auto-detection enabled")
 
 	// 3. Initialize Serial Manager with auto-detection
 	// Port starts as nil, Scanner will auto-detect and connect
 	manager := driver.NewSerialManager(nil)
 
-	// 4. Initialize API Handler
-	handler := api.NewHandler(manager)
+	// 4. Open transaction ledger (history)
+	led, err := ledger.Open(filepath.Join(cfg.DataDir, "ledger.db"))
+	if err != nil {
+		logger.Error("Failed to open transaction ledger: %v", err)
+		fmt.Printf("Warning: Transaction history disabled: %v\n", err)
+	} else {
+		defer led.Close()
+	}
+
+	// 5. Open transaction journal and report interrupted transactions
+	j, unresolved, err := journal.Open(filepath.Join(cfg.DataDir, "journal.jsonl"))
+	if err != nil {
+		logger.Error("Failed to open transaction journal: %v", err)
+		fmt.Printf("Warning: Transaction journal disabled: %v\n", err)
+	} else {
+		defer j.Close()
+		for _, e := range unresolved {
+			if e.Phase == journal.PhaseInterrupted {
+				fmt.Printf("WARNING: interrupted transaction %s (Type=%s Amount=%s) needs manual reconciliation\n",
+					e.ID, e.TransType, e.Amount)
+			}
+			if led != nil {
+				if err := led.MarkUnknown(e.ID, e.Error); err != nil {
+					logger.Error("Failed to mark transaction %s unknown in the ledger: %v", e.ID, err)
+				}
+			}
+		}
+		manager.SetJournal(j, unresolved)
+	}
+
+	// 6. Initialize API Handler
+	handler := api.NewHandler(manager, led)
+	handler.SetIdempotencyWindow(cfg.IdempotencyWindow)
+
+	// Credentials, policy, webhooks and hooks are reloaded by RESTART
+	rc := &runtimeConfig{cfg: cfg, handler: handler}
+	if err := rc.apply(); err != nil {
+		logger.Error("%v", err)
+		log.Fatal(err)
+	}
+	defer rc.close()
+	handler.SetReloader(rc.apply)
+
+	// 7. Load TLS certificates
+	var certManager *certs.Manager
+	if cfg.TLS {
+		if cfg.TLSCert != "" {
+			certManager, err = certs.NewManager(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			certManager, err = certs.NewLocalManager(filepath.Join(cfg.DataDir, "tls"))
+		}
+		if err != nil {
+			logger.Error("TLS setup failed: %v", err)
+			log.Fatal("TLS setup failed: ", err)
+		}
+		certManager.Start()
+		defer certManager.Stop()
+		handler.SetCertificates(certManager)
+	}
+
+	// 8. Start gRPC Server on its own listener
+	var grpcServer *grpc.Server
+	if cfg.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			logger.Error("gRPC listen failed: %v", err)
+			log.Fatal("gRPC listen failed: ", err)
+		}
+		grpcServer = handler.NewGRPCServer()
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				logger.Error("gRPC server stopped: %v", err)
+			}
+		}()
+		logger.Info("gRPC server listening on %s", cfg.GRPCAddr)
+		fmt.Printf("gRPC server listening on %s\n", cfg.GRPCAddr)
+	}
 
-	// 5. Start HTTP Server
-	http.HandleFunc("/ws", handler.ServeWS)
+	// 9. Start HTTP Server (WebSocket + REST)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	server := &http.Server{Addr: cfg.WSAddr, Handler: mux}
+	serveErr := make(chan error, 1)
+
+	if certManager == nil {
+		logger.Info("WebSocket server listening on %s", cfg.WSAddr)
+		fmt.Printf("WebSocket server listening on %s\n", cfg.WSAddr)
+		go func() { serveErr <- server.ListenAndServe() }()
+	} else {
+		info := certManager.Info()
+		if info.CAFile != "" {
+			fmt.Printf("Using local CA %s - trust it on client machines to avoid certificate warnings\n", info.CAFile)
+		}
+		server.TLSConfig = certManager.TLSConfig()
+		logger.Info("WebSocket server listening on %s (TLS, certificate expires %s)", cfg.WSAddr, info.NotAfter.Format("2006-01-02"))
+		fmt.Printf("WebSocket server listening on %s (TLS)\n", cfg.WSAddr)
+		go func() { serveErr <- server.ListenAndServeTLS("", "") }()
+	}
 
-	logger.Info("WebSocket server listening on %s", cfg.WSAddr)
-	fmt.Printf("WebSocket server listening on %s\n", cfg.WSAddr)
+	// 10. Wait for a signal or RESTART, then shut down gracefully
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
 
-	if err := http.ListenAndServe(cfg.WSAddr, nil); err != nil {
+	stdinClosed := make(chan struct{})
+	if cfg.ExitOnStdinClose {
+		go func() {
+			io.Copy(io.Discard, os.Stdin)
+			close(stdinClosed)
+		}()
+	}
+
+	var reason string
+	select {
+	case err := <-serveErr:
 		logger.Error("ListenAndServe failed: %v", err)
 		log.Fatal("ListenAndServe:", err)
+	case <-signals.Done():
+		reason = "signal"
+	case <-stdinClosed:
+		reason = "stdin closed"
+	case reason = <-handler.ShutdownRequested():
+	}
+	stopSignals() // A second signal kills the process
+	fmt.Printf("Shutting down (%s), waiting up to %s for the running transaction...\n", reason, cfg.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := handler.Shutdown(ctx, reason); err != nil {
+		logger.Warn("Shutdown: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Warn("HTTP server shutdown: %v", err)
+	}
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+		}
+	}
+
+	handler.Manager().Close()
+	logger.Info("Server stopped")
+	fmt.Println("Server stopped")
+}
+
+// runtimeConfig applies the configuration a soft restart reloads: the
+// credentials, webhooks and transaction hooks of the config file, and the
+// policy file
+type runtimeConfig struct {
+	cfg      *config.Config
+	handler  *api.Handler
+	outbox   *webhook.Dispatcher
+	webhooks []webhook.Target // Targets the outbox was opened with
+}
+
+// apply reads the config and policy files and hands them to the handler.
+// If either is invalid, or the webhook outbox cannot be opened, nothing
+// changes. rc.cfg itself is left alone, as main reads it concurrently.
+func (rc *runtimeConfig) apply() error {
+	next := *rc.cfg
+	if err := next.LoadFile(); err != nil {
+		return err
+	}
+	next.Auth.Insecure = next.InsecureNoAuth
+	authenticator, err := auth.New(next.Auth)
+	if err != nil {
+		return fmt.Errorf("invalid auth config: %v", err)
+	}
+	if !authenticator.Enabled() && !next.InsecureNoAuth {
+		return fmt.Errorf("no credentials configured: add tokens or hmac_keys to the -config file, or pass -insecure-no-auth")
+	}
+	var policy *auth.Policy
+	if next.PolicyFile != "" {
+		if policy, err = auth.LoadPolicy(next.PolicyFile); err != nil {
+			return err
+		}
+	}
+	if err := webhook.Validate(next.Webhooks); err != nil {
+		return err
+	}
+	hookSet, err := hooks.Build(next.Hooks)
+	if err != nil {
+		return fmt.Errorf("invalid hook config: %v", err)
+	}
+
+	// Everything that can fail is ready: swap the webhook outbox first, as
+	// only that can still fail, then the rest
+	if !reflect.DeepEqual(next.Webhooks, rc.webhooks) {
+		if err := rc.reopenOutbox(next.DataDir, next.Webhooks); err != nil {
+			return err
+		}
+	}
+
+	if authenticator.AllowsAnonymous() {
+		logger.Warn("Authentication disabled by -insecure-no-auth")
+		fmt.Println("WARNING: authentication disabled (-insecure-no-auth), any local client can send commands")
+	}
+	rc.handler.SetAuthenticator(authenticator)
+
+	if policy != nil && !authenticator.Enabled() {
+		fmt.Println("WARNING: policy loaded but authentication is disabled; restricted commands will be denied")
+	}
+	rc.handler.SetPolicy(policy)
+	if policy != nil {
+		logger.Info("Loaded command policy from %s", next.PolicyFile)
+	}
+
+	rc.handler.Hooks().Configure(hookSet)
+	if hookSet.Len() > 0 {
+		logger.Info("Transaction hooks enabled: %d configured", hookSet.Len())
+	}
+	return nil
+}
+
+// reopenOutbox replaces the webhook dispatcher with one for targets. The
+// outbox file is locked while open, so the old dispatcher is stopped first
+// and reopened if the new one cannot be opened.
+func (rc *runtimeConfig) reopenOutbox(dataDir string, targets []webhook.Target) error {
+	path := filepath.Join(dataDir, "outbox.db")
+	rc.handler.SetWebhooks(nil)
+	rc.close()
+
+	var outbox *webhook.Dispatcher
+	if len(targets) > 0 {
+		var err error
+		if outbox, err = webhook.Open(path, targets); err != nil {
+			rc.restoreOutbox(path)
+			return fmt.Errorf("webhook setup failed: %v", err)
+		}
+		outbox.Start()
+		logger.Info("Webhooks enabled for %d target(s)", len(targets))
+	}
+	rc.outbox, rc.webhooks = outbox, targets
+	if outbox != nil {
+		rc.handler.SetWebhooks(outbox)
+	}
+	return nil
+}
+
+// restoreOutbox reopens the stopped dispatcher of the current targets
+func (rc *runtimeConfig) restoreOutbox(path string) {
+	rc.outbox = nil
+	if len(rc.webhooks) == 0 {
+		return
+	}
+	outbox, err := webhook.Open(path, rc.webhooks)
+	if err != nil {
+		logger.Error("Failed to reopen the previous webhook outbox, webhooks are off: %v", err)
+		rc.webhooks = nil
+		return
+	}
+	outbox.Start()
+	rc.outbox = outbox
+	rc.handler.SetWebhooks(outbox)
+}
+
+// close stops the webhook dispatcher, if any
+func (rc *runtimeConfig) close() {
+	if rc.outbox != nil {
+		rc.outbox.Stop()
 	}
 }
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// Transactions counts finished transactions by command and outcome
+	Transactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecpay_transactions_total",
+		Help: "Finished transactions by type and outcome.",
+	}, []string{"type", "outcome"})
+
+	// PhaseDuration observes how long each wait phase of a transaction took
+	PhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ecpay_phase_duration_seconds",
+		Help:    "Duration of transaction phases (ack_wait, response_wait).",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 45, 65},
+	}, []string{"phase"})
+
+	// NAKs counts NAK replies from the terminal
+	NAKs = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ecpay_nak_total",
+		Help: "NAK replies received from the POS terminal.",
+	})
+
+	// LRCFailures counts response frames that failed the checksum
+	LRCFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ecpay_lrc_failures_total",
+		Help: "Response frames rejected for an invalid LRC checksum.",
+	})
+
+	// Reconnects counts reconnect requests
+	Reconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ecpay_reconnects_total",
+		Help: "Reconnects to the POS terminal.",
+	})
+
+	// ScanCycles counts device scan cycles by result (found, not_found)
+	ScanCycles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecpay_scan_cycles_total",
+		Help: "POS device scan cycles by result.",
+	}, []string{"result"})
+
+	// TLSCertExpiry is the expiry time of the served TLS certificate
+	TLSCertExpiry = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ecpay_tls_cert_expiry_timestamp_seconds",
+		Help: "Expiry of the TLS certificate being served (Unix time).",
+	})
+
+	// WebhookDeliveries counts webhook delivery attempts by result
+	// (delivered, retry, failed, dropped)
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecpay_webhook_deliveries_total",
+		Help: "Webhook delivery attempts by result.",
+	}, []string{"result"})
+
+	// WebhookOutbox tracks deliveries waiting in the webhook outbox
+	WebhookOutbox = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ecpay_webhook_outbox",
+		Help: "Webhook deliveries waiting to be sent.",
+	})
+
+	// WebSocketClients tracks currently connected WebSocket clients
+	WebSocketClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ecpay_websocket_clients",
+		Help: "Connected WebSocket clients.",
+	})
+
+	// WebSocketEvictions counts clients disconnected by the server by reason
+	// (slow_client, ping_timeout, write_error)
+	WebSocketEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecpay_websocket_evictions_total",
+		Help: "WebSocket clients disconnected by the server, by reason.",
+	}, []string{"reason"})
+
+	// WebSocketDropped counts status updates skipped for clients that are
+	// falling behind
+	WebSocketDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ecpay_websocket_dropped_updates_total",
+		Help: "Status updates not queued for slow WebSocket clients.",
+	})
+
+	// EventStreamClients tracks clients of the Server-Sent Events stream and
+	// gRPC status watchers
+	EventStreamClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ecpay_event_stream_clients",
+		Help: "Connected Server-Sent Events clients and gRPC status watchers.",
+	})
+
+	// DriverEventsDropped counts driver events not delivered to a bus
+	// subscriber whose queue was full
+	DriverEventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecpay_driver_events_dropped_total",
+		Help: "Driver events dropped for slow internal subscribers.",
+	}, []string{"subscriber"})
+)
+
+// Phase labels for PhaseDuration
+const (
+	PhaseACKWait      = "ack_wait"
+	PhaseResponseWait = "response_wait"
+)
+
+// Handler returns the Prometheus scrape handler
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
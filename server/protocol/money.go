@@ -0,0 +1,143 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money 金额，以最小单位 (分) 计。报文的 Trans Amount 字段为 12 位数字，
+// 不含小数点，末两位为分: "000000010000" = 100.00
+type Money int64
+
+// AmountFieldLen 金额字段长度
+const AmountFieldLen = 12
+
+// MaxMoney 12 位字段可表示的最大金额 (分)
+const MaxMoney Money = 999999999999
+
+// fieldDecimals 金额字段的小数位数 (分)
+const fieldDecimals = 2
+
+// Currency 币别。Decimals 为输入允许的小数位数，不得超过字段精度 (2 位)
+type Currency struct {
+	Code     string
+	Decimals int
+}
+
+// TWD 新台币，终端机报文使用的币别
+var TWD = Currency{Code: "TWD", Decimals: 2}
+
+// ParseAmount 按 TWD 解析十进制金额，例如 "100"、"100.00"、"1,250"
+func ParseAmount(s string) (Money, error) {
+	return TWD.Parse(s)
+}
+
+// Parse 解析十进制金额 (以元计，可含千分位逗号)。拒绝负数、超出字段
+// 范围以及小数位数超过币别精度的金额
+func (c Currency) Parse(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("amount is empty")
+	}
+	if s[0] == '-' {
+		return 0, fmt.Errorf("amount %q is negative", s)
+	}
+
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	if hasPoint && frac == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	whole, err := stripGrouping(whole)
+	if err != nil || whole == "" || !isDigits(whole) || !isDigits(frac) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if len(frac) > c.Decimals {
+		return 0, fmt.Errorf("amount %q has more than %d decimal places for %s", s, c.Decimals, c.Code)
+	}
+
+	// 去掉前导零后按位数判断上限，避免溢出
+	whole = strings.TrimLeft(whole, "0")
+	if len(whole) > AmountFieldLen-fieldDecimals {
+		return 0, fmt.Errorf("amount %q exceeds maximum %s", s, MaxMoney)
+	}
+	digits := whole + frac + strings.Repeat("0", fieldDecimals-len(frac))
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return Money(n), nil
+}
+
+// stripGrouping 去掉千分位逗号，逗号必须每三位一组
+func stripGrouping(s string) (string, error) {
+	if !strings.Contains(s, ",") {
+		return s, nil
+	}
+	groups := strings.Split(s, ",")
+	if len(groups[0]) == 0 || len(groups[0]) > 3 {
+		return "", fmt.Errorf("bad grouping")
+	}
+	for _, g := range groups[1:] {
+		if len(g) != 3 {
+			return "", fmt.Errorf("bad grouping")
+		}
+	}
+	return strings.Join(groups, ""), nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseAmountField 解析报文中的 12 位金额字段 (分)
+func ParseAmountField(field string) (Money, error) {
+	field = strings.TrimSpace(field)
+	if field == "" || len(field) > AmountFieldLen || !isDigits(field) {
+		return 0, fmt.Errorf("invalid amount field %q", field)
+	}
+	n, err := strconv.ParseInt(field, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount field %q", field)
+	}
+	return Money(n), nil
+}
+
+// Field 返回报文金额字段: 12 位，左补 0
+func (m Money) Field() string {
+	return fmt.Sprintf("%0*d", AmountFieldLen, int64(m))
+}
+
+// String 返回两位小数的十进制金额，例如 "1250.00"
+func (m Money) String() string {
+	return fmt.Sprintf("%d.%02d", int64(m)/100, int64(m)%100)
+}
+
+// MarshalJSON 以十进制字符串输出，例如 "1250.00"
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON 接受十进制字符串或数字 (以元计)
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid amount %s", data)
+		}
+		s = n.String()
+	}
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
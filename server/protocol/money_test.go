@@ -0,0 +1,75 @@
+package protocol
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: "100", want: 10000},
+		{in: "100.00", want: 10000},
+		{in: "100.5", want: 10050},
+		{in: " 7.25 ", want: 725},
+		{in: "0", want: 0},
+		{in: "0.00", want: 0},
+		{in: "000120", want: 12000},
+		{in: "1,250", want: 125000},
+		{in: "1,234,567.89", want: 123456789},
+		{in: "9999999999.99", want: MaxMoney},
+
+		{in: "", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "-0.50", wantErr: true},
+		{in: "1.234", wantErr: true},
+		{in: "1.", wantErr: true},
+		{in: ".5", wantErr: true},
+		{in: "1,25", wantErr: true},
+		{in: "12,50,000", wantErr: true},
+		{in: "1250,000", wantErr: true},
+		{in: ",100", wantErr: true},
+		{in: "1e3", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "10000000000", wantErr: true},
+		{in: "99999999999999999999", wantErr: true},
+	} {
+		got, err := ParseAmount(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseAmount(%q) = %s, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("ParseAmount(%q) = %s, %v; want %s", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestCurrencyWithoutMinorUnits(t *testing.T) {
+	jpy := Currency{Code: "JPY", Decimals: 0}
+	for _, tc := range []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: "1500", want: 150000},
+		{in: "1,500", want: 150000},
+		{in: "0", want: 0},
+		{in: "1.5", wantErr: true},
+		{in: "1.0", wantErr: true},
+		{in: "-1", wantErr: true},
+	} {
+		got, err := jpy.Parse(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("JPY Parse(%q) = %s, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("JPY Parse(%q) = %s, %v; want %s", tc.in, got, err, tc.want)
+		}
+	}
+}
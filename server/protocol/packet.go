@@ -20,7 +20,7 @@ const (
 type ECPayRequest struct {
 	TransType string // 01:Sale, 02:Refund, 60:Void, 50:Settle, 80:Echo
 	HostID    string // 01:CreditCard
-	Amount    string // 12 chars, no decimal
+	Amount    Money  // 以分计，写入 12 位字段
 	OrderNo   string // 20 chars, for Refund/Void ref
 	PosTime   string // 14 chars, YYYYMMDDHHMMSS
 }
@@ -55,12 +55,8 @@ func BuildPacket(req ECPayRequest) []byte {
 	// 5. CUP Flag (29-31)
 	writeField(29, 2, "00", "LEFT_ZERO")
 
-	// 6. Amount (31-43) - 金额 (无小数点)
-	if req.Amount != "" {
-		writeField(31, 12, req.Amount, "LEFT_ZERO")
-	} else {
-		writeField(31, 12, "0", "LEFT_ZERO")
-	}
+	// 6. Amount (31-43) - 金额 (无小数点，末两位为分)
+	writeField(31, AmountFieldLen, req.Amount.Field(), "LEFT_ZERO")
 
 	// 13. EC Order No (88-108) - 用于退货/取消的原单号
 	if req.OrderNo != "" {
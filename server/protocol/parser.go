@@ -32,22 +32,52 @@ func ParseResponse(packet []byte) map[string]string {
 	}
 
 	return map[string]string{
-		"TransType":  readField(0, 2),
-		"HostID":     readField(2, 2),
-		"Amount":     readField(31, 12),
-		"TransDate":  readField(43, 6),
-		"TransTime":  readField(49, 6),
-		"ApprovalNo": readField(55, 6),   // 授权码
-		"RespCode":   readField(61, 4),   // 0000 = Success
-		"TerminalID": readField(65, 8),   // 终端机号
-		"MerchantID": readField(73, 15),  // 商店代号
-		"OrderNo":    readField(88, 20),  // 绿界单号
-		"StoreID":    readField(108, 18), // 柜号
-		"CardType":   readField(126, 2),  // 卡片代码: 00=VISA, 01=MC, 02=JCB, 03=CUP
-		"CardNo":     readField(10, 19),  // 掩码卡号
+		"TransType":   readField(0, 2),
+		"HostID":      readField(2, 2),
+		"Amount":      amountField(readField(31, 12)), // 转为十进制金额
+		"TransDate":   readField(43, 6),
+		"TransTime":   readField(49, 6),
+		"ApprovalNo":  readField(55, 6),   // 授权码
+		"RespCode":    readField(61, 4),   // 0000 = Success
+		"TerminalID":  readField(65, 8),   // 终端机号
+		"MerchantID":  readField(73, 15),  // 商店代号
+		"OrderNo":     readField(88, 20),  // 绿界单号
+		"StoreID":     readField(108, 18), // 柜号
+		"CardType":    readField(126, 2),  // 卡片代码: 00=VISA, 01=MC, 02=JCB, 03=CUP
+		"CardNo":      readField(10, 19),  // 掩码卡号
+		"PosTime":     readField(492, 14), // 收银机请求时间 (原样回传)
+		"RequestHash": readField(506, 40), // 请求哈希值 (原样回传)
+		"EDCTime":     readField(546, 14), // 刷卡机系统时间
 	}
 }
 
+// amountField 把 12 位金额字段 (分) 转为十进制金额，如 "000000010000" -> "100.00"。
+// 无法解析时原样返回
+func amountField(field string) string {
+	m, err := ParseAmountField(field)
+	if err != nil {
+		return field
+	}
+	return m.String()
+}
+
+// FrameRequestHash 取出完整帧 (STX + DATA + ETX + LRC) 中的 Request Hash 字段
+// 请求与回应使用同一位置，可用于把回应对应回原始请求
+func FrameRequestHash(packet []byte) string {
+	if len(packet) != 603 {
+		return ""
+	}
+	return string(bytes.TrimSpace(packet[1+506 : 1+546]))
+}
+
+// FramePosTime 取出完整帧中的 POS Request Time 字段
+func FramePosTime(packet []byte) string {
+	if len(packet) != 603 {
+		return ""
+	}
+	return string(bytes.TrimSpace(packet[1+492 : 1+506]))
+}
+
 // ValidatePacket 校验接收到的完整帧是否合法 (LRC 校验)
 func ValidatePacket(packet []byte) bool {
 	if len(packet) != 603 {
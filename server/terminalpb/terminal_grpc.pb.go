@@ -0,0 +1,296 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: terminal.proto
+
+// Typed API of the ECPay terminal gateway. It runs the same commands as the
+// WebSocket and REST endpoints, against the same POS terminal.
+
+package terminalpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TerminalGateway_ExecuteTransaction_FullMethodName = "/ecpay.terminal.v1.TerminalGateway/ExecuteTransaction"
+	TerminalGateway_GetStatus_FullMethodName          = "/ecpay.terminal.v1.TerminalGateway/GetStatus"
+	TerminalGateway_Abort_FullMethodName              = "/ecpay.terminal.v1.TerminalGateway/Abort"
+	TerminalGateway_Reconnect_FullMethodName          = "/ecpay.terminal.v1.TerminalGateway/Reconnect"
+	TerminalGateway_WatchStatus_FullMethodName        = "/ecpay.terminal.v1.TerminalGateway/WatchStatus"
+)
+
+// TerminalGatewayClient is the client API for TerminalGateway service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TerminalGatewayClient interface {
+	// Runs a transaction and returns once the terminal has answered. Declined
+	// and failed transactions are results, not errors; errors are reserved for
+	// requests that never ran (PERMISSION_DENIED, ABORTED when the terminal is
+	// busy, INVALID_ARGUMENT).
+	ExecuteTransaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*TransactionResult, error)
+	// Returns the terminal and transaction state
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*TerminalStatus, error)
+	// Cancels the running transaction
+	Abort(ctx context.Context, in *AbortRequest, opts ...grpc.CallOption) (*ControlResult, error)
+	// Reconnects to the terminal, rescanning ports if needed
+	Reconnect(ctx context.Context, in *ReconnectRequest, opts ...grpc.CallOption) (*ControlResult, error)
+	// Streams the current status, then every status change
+	WatchStatus(ctx context.Context, in *WatchStatusRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[TerminalStatus], error)
+}
+
+type terminalGatewayClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTerminalGatewayClient(cc grpc.ClientConnInterface) TerminalGatewayClient {
+	return &terminalGatewayClient{cc}
+}
+
+func (c *terminalGatewayClient) ExecuteTransaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*TransactionResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionResult)
+	err := c.cc.Invoke(ctx, TerminalGateway_ExecuteTransaction_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *terminalGatewayClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*TerminalStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TerminalStatus)
+	err := c.cc.Invoke(ctx, TerminalGateway_GetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *terminalGatewayClient) Abort(ctx context.Context, in *AbortRequest, opts ...grpc.CallOption) (*ControlResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ControlResult)
+	err := c.cc.Invoke(ctx, TerminalGateway_Abort_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *terminalGatewayClient) Reconnect(ctx context.Context, in *ReconnectRequest, opts ...grpc.CallOption) (*ControlResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ControlResult)
+	err := c.cc.Invoke(ctx, TerminalGateway_Reconnect_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *terminalGatewayClient) WatchStatus(ctx context.Context, in *WatchStatusRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[TerminalStatus], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TerminalGateway_ServiceDesc.Streams[0], TerminalGateway_WatchStatus_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchStatusRequest, TerminalStatus]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TerminalGateway_WatchStatusClient = grpc.ServerStreamingClient[TerminalStatus]
+
+// TerminalGatewayServer is the server API for TerminalGateway service.
+// All implementations must embed UnimplementedTerminalGatewayServer
+// for forward compatibility.
+type TerminalGatewayServer interface {
+	// Runs a transaction and returns once the terminal has answered. Declined
+	// and failed transactions are results, not errors; errors are reserved for
+	// requests that never ran (PERMISSION_DENIED, ABORTED when the terminal is
+	// busy, INVALID_ARGUMENT).
+	ExecuteTransaction(context.Context, *TransactionRequest) (*TransactionResult, error)
+	// Returns the terminal and transaction state
+	GetStatus(context.Context, *GetStatusRequest) (*TerminalStatus, error)
+	// Cancels the running transaction
+	Abort(context.Context, *AbortRequest) (*ControlResult, error)
+	// Reconnects to the terminal, rescanning ports if needed
+	Reconnect(context.Context, *ReconnectRequest) (*ControlResult, error)
+	// Streams the current status, then every status change
+	WatchStatus(*WatchStatusRequest, grpc.ServerStreamingServer[TerminalStatus]) error
+	mustEmbedUnimplementedTerminalGatewayServer()
+}
+
+// UnimplementedTerminalGatewayServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTerminalGatewayServer struct{}
+
+func (UnimplementedTerminalGatewayServer) ExecuteTransaction(context.Context, *TransactionRequest) (*TransactionResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExecuteTransaction not implemented")
+}
+func (UnimplementedTerminalGatewayServer) GetStatus(context.Context, *GetStatusRequest) (*TerminalStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedTerminalGatewayServer) Abort(context.Context, *AbortRequest) (*ControlResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Abort not implemented")
+}
+func (UnimplementedTerminalGatewayServer) Reconnect(context.Context, *ReconnectRequest) (*ControlResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reconnect not implemented")
+}
+func (UnimplementedTerminalGatewayServer) WatchStatus(*WatchStatusRequest, grpc.ServerStreamingServer[TerminalStatus]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchStatus not implemented")
+}
+func (UnimplementedTerminalGatewayServer) mustEmbedUnimplementedTerminalGatewayServer() {}
+func (UnimplementedTerminalGatewayServer) testEmbeddedByValue()                         {}
+
+// UnsafeTerminalGatewayServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TerminalGatewayServer will
+// result in compilation errors.
+type UnsafeTerminalGatewayServer interface {
+	mustEmbedUnimplementedTerminalGatewayServer()
+}
+
+func RegisterTerminalGatewayServer(s grpc.ServiceRegistrar, srv TerminalGatewayServer) {
+	// If the following call pancis, it indicates UnimplementedTerminalGatewayServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TerminalGateway_ServiceDesc, srv)
+}
+
+func _TerminalGateway_ExecuteTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TerminalGatewayServer).ExecuteTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TerminalGateway_ExecuteTransaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TerminalGatewayServer).ExecuteTransaction(ctx, req.(*TransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TerminalGateway_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TerminalGatewayServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TerminalGateway_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TerminalGatewayServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TerminalGateway_Abort_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AbortRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TerminalGatewayServer).Abort(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TerminalGateway_Abort_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TerminalGatewayServer).Abort(ctx, req.(*AbortRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TerminalGateway_Reconnect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReconnectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TerminalGatewayServer).Reconnect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TerminalGateway_Reconnect_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TerminalGatewayServer).Reconnect(ctx, req.(*ReconnectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TerminalGateway_WatchStatus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchStatusRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TerminalGatewayServer).WatchStatus(m, &grpc.GenericServerStream[WatchStatusRequest, TerminalStatus]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TerminalGateway_WatchStatusServer = grpc.ServerStreamingServer[TerminalStatus]
+
+// TerminalGateway_ServiceDesc is the grpc.ServiceDesc for TerminalGateway service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TerminalGateway_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ecpay.terminal.v1.TerminalGateway",
+	HandlerType: (*TerminalGatewayServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ExecuteTransaction",
+			Handler:    _TerminalGateway_ExecuteTransaction_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _TerminalGateway_GetStatus_Handler,
+		},
+		{
+			MethodName: "Abort",
+			Handler:    _TerminalGateway_Abort_Handler,
+		},
+		{
+			MethodName: "Reconnect",
+			Handler:    _TerminalGateway_Reconnect_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchStatus",
+			Handler:       _TerminalGateway_WatchStatus_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "terminal.proto",
+}
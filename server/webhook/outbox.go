@@ -0,0 +1,369 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"ecpay-server/logger"
+	"ecpay-server/metrics"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// MaxAttempts is how often a delivery is tried before it is moved to the
+	// failed bucket. With the backoff below that is about 10 hours.
+	MaxAttempts = 20
+
+	retryMax        = time.Hour
+	deliveryTimeout = 10 * time.Second
+	idlePoll        = time.Minute // Rescan interval when nothing is scheduled
+)
+
+// Delivery results for metrics.WebhookDeliveries
+const (
+	resultDelivered = "delivered"
+	resultRetry     = "retry"
+	resultFailed    = "failed"  // Gave up after MaxAttempts
+	resultDropped   = "dropped" // Target no longer configured
+)
+
+// retryBase is the delay before the first retry, doubled for each further
+// one. Tests shorten it.
+var retryBase = 5 * time.Second
+
+var (
+	bucketPending = []byte("pending") // "<event ID>/<url>" -> Delivery
+	bucketFailed  = []byte("failed")  // Deliveries that exhausted their attempts
+)
+
+// Delivery is one event queued for one target
+type Delivery struct {
+	EventID     string          `json:"event_id"`
+	EventType   string          `json:"event_type"`
+	URL         string          `json:"url"`
+	Body        json.RawMessage `json:"body"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Dispatcher stores events in a persistent outbox and posts them to the
+// configured targets, retrying failures with exponential backoff. Deliveries
+// to one target are sent in order; a failing target holds back only its own
+// queue.
+type Dispatcher struct {
+	db      *bolt.DB
+	targets map[string]Target // By URL
+	client  *http.Client
+
+	wake     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Open opens (or creates) the outbox at path. Deliveries left over from a
+// previous run are sent once Start is called.
+func Open(path string, targets []Target) (*Dispatcher, error) {
+	byURL := make(map[string]Target)
+	for _, t := range targets {
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+		byURL[t.URL] = t
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %v", err)
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open webhook outbox: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketPending, bucketFailed} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize webhook outbox: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		db:      db,
+		targets: byURL,
+		client:  &http.Client{Timeout: deliveryTimeout},
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Start begins delivering queued events
+func (d *Dispatcher) Start() {
+	go d.run()
+}
+
+// Stop stops delivering and closes the outbox. Undelivered events stay
+// queued for the next run.
+func (d *Dispatcher) Stop() {
+	d.stopOnce.Do(func() {
+		d.cancel()
+		<-d.done
+		d.db.Close()
+	})
+}
+
+// Publish queues an event for every target subscribed to its type. The
+// event is on disk when Publish returns.
+func (d *Dispatcher) Publish(eventType string, data interface{}) error {
+	now := time.Now()
+	event := Event{ID: newEventID(now), Type: eventType, CreatedAt: now, Data: data}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %v", eventType, err)
+	}
+
+	queued := 0
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketPending)
+		for url, t := range d.targets {
+			if !t.wants(eventType) {
+				continue
+			}
+			rec, err := json.Marshal(Delivery{
+				EventID:     event.ID,
+				EventType:   eventType,
+				URL:         url,
+				Body:        body,
+				NextAttempt: now,
+				CreatedAt:   now,
+			})
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(event.ID+"/"+url), rec); err != nil {
+				return err
+			}
+			queued++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to queue %s event: %v", eventType, err)
+	}
+
+	if queued > 0 {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// run delivers due events, then sleeps until the next retry or a new event
+func (d *Dispatcher) run() {
+	defer close(d.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+
+		next := d.deliverDue()
+		wait := idlePoll
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+		timer.Stop()
+		timer.Reset(wait)
+	}
+}
+
+type queued struct {
+	key []byte
+	Delivery
+}
+
+// deliverDue attempts every due delivery and returns when the next retry is
+// scheduled (zero if none)
+func (d *Dispatcher) deliverDue() time.Time {
+	var pending []queued
+	d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPending).ForEach(func(k, v []byte) error {
+			var del Delivery
+			if err := json.Unmarshal(v, &del); err != nil {
+				logger.Error("Corrupt webhook delivery %s: %v", k, err)
+				return nil
+			}
+			pending = append(pending, queued{key: append([]byte(nil), k...), Delivery: del})
+			return nil
+		})
+	})
+	defer d.updateGauge()
+
+	var next time.Time
+	schedule := func(t time.Time) {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+
+	// Keys sort by event time, so the first delivery seen for a target is
+	// the head of its queue; the rest wait behind it
+	blocked := make(map[string]bool)
+	for _, q := range pending {
+		if d.ctx.Err() != nil {
+			return next
+		}
+		if blocked[q.URL] {
+			continue
+		}
+		if q.NextAttempt.After(time.Now()) {
+			blocked[q.URL] = true
+			schedule(q.NextAttempt)
+			continue
+		}
+
+		target, ok := d.targets[q.URL]
+		if !ok {
+			logger.Warn("Dropping webhook %s %s: target %s no longer configured", q.EventType, q.EventID, q.URL)
+			metrics.WebhookDeliveries.WithLabelValues(resultDropped).Inc()
+			d.remove(q.key, nil)
+			continue
+		}
+
+		err := d.deliver(target, q.Delivery)
+		if err == nil {
+			logger.Debug("Webhook %s %s delivered to %s", q.EventType, q.EventID, q.URL)
+			metrics.WebhookDeliveries.WithLabelValues(resultDelivered).Inc()
+			d.remove(q.key, nil)
+			continue
+		}
+		if d.ctx.Err() != nil {
+			// Shutting down; the attempt does not count
+			return next
+		}
+
+		blocked[q.URL] = true
+		q.Attempts++
+		q.LastError = err.Error()
+		if q.Attempts >= MaxAttempts {
+			logger.Error("Webhook %s %s to %s failed after %d attempts, giving up: %v", q.EventType, q.EventID, q.URL, q.Attempts, err)
+			metrics.WebhookDeliveries.WithLabelValues(resultFailed).Inc()
+			d.remove(q.key, &q.Delivery)
+			// The next delivery for this target may go now
+			schedule(time.Now())
+			continue
+		}
+		q.NextAttempt = time.Now().Add(backoff(q.Attempts))
+		logger.Warn("Webhook %s %s to %s failed (attempt %d, retry at %s): %v",
+			q.EventType, q.EventID, q.URL, q.Attempts, q.NextAttempt.Format(time.RFC3339), err)
+		metrics.WebhookDeliveries.WithLabelValues(resultRetry).Inc()
+		d.update(q.key, q.Delivery)
+		schedule(q.NextAttempt)
+	}
+	return next
+}
+
+// deliver posts one delivery and succeeds on any 2xx response
+func (d *Dispatcher) deliver(target Target, del Delivery) error {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, del.URL, bytes.NewReader(del.Body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ecpay-server-webhook")
+	req.Header.Set(HeaderEvent, del.EventType)
+	req.Header.Set(HeaderDelivery, del.EventID)
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderSignature, Sign([]byte(target.Secret), ts, del.Body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// remove deletes a delivery from the outbox, keeping it in the failed
+// bucket if failed is set
+func (d *Dispatcher) remove(key []byte, failed *Delivery) {
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		if failed != nil {
+			data, err := json.Marshal(failed)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketFailed).Put(key, data); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketPending).Delete(key)
+	})
+	if err != nil {
+		logger.Error("Webhook outbox write failed: %v", err)
+	}
+}
+
+// update stores a delivery's retry state
+func (d *Dispatcher) update(key []byte, del Delivery) {
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(del)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketPending).Put(key, data)
+	})
+	if err != nil {
+		logger.Error("Webhook outbox write failed: %v", err)
+	}
+}
+
+func (d *Dispatcher) updateGauge() {
+	d.db.View(func(tx *bolt.Tx) error {
+		metrics.WebhookOutbox.Set(float64(tx.Bucket(bucketPending).Stats().KeyN))
+		return nil
+	})
+}
+
+// backoff returns the delay before retry number attempts
+func backoff(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts && delay < retryMax; i++ {
+		delay *= 2
+	}
+	if delay > retryMax {
+		delay = retryMax
+	}
+	return delay
+}
@@ -0,0 +1,183 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// receiver is a webhook endpoint that fails its first failures requests
+type receiver struct {
+	t        *testing.T
+	secret   string
+	mu       sync.Mutex
+	failures int
+	attempts []string // Delivery IDs of every request, in order
+	received []Event  // Events accepted, in order
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if got, want := r.Header.Get(HeaderSignature), Sign([]byte(rc.secret), r.Header.Get(HeaderTimestamp), body); got != want {
+		rc.t.Errorf("signature %q, want %q over %s.%s", got, want, r.Header.Get(HeaderTimestamp), body)
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		rc.t.Errorf("body %s: %v", body, err)
+	}
+	if r.Header.Get(HeaderDelivery) != event.ID || r.Header.Get(HeaderEvent) != event.Type {
+		rc.t.Errorf("headers %s=%s %s=%s do not match event %s %s", HeaderDelivery, r.Header.Get(HeaderDelivery),
+			HeaderEvent, r.Header.Get(HeaderEvent), event.ID, event.Type)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.attempts = append(rc.attempts, event.ID)
+	if rc.failures > 0 {
+		rc.failures--
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+	rc.received = append(rc.received, event)
+}
+
+// wait returns the accepted events once there are n of them
+func (rc *receiver) wait(n int) []Event {
+	rc.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		rc.mu.Lock()
+		got := append([]Event(nil), rc.received...)
+		rc.mu.Unlock()
+		if len(got) >= n {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rc.t.Fatalf("timed out waiting for %d deliveries", n)
+	return nil
+}
+
+// queuedDeliveries returns the pending deliveries in outbox order
+func queuedDeliveries(d *Dispatcher) []Delivery {
+	var pending []Delivery
+	d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPending).ForEach(func(_, v []byte) error {
+			var del Delivery
+			json.Unmarshal(v, &del)
+			pending = append(pending, del)
+			return nil
+		})
+	})
+	return pending
+}
+
+func shortRetries(t *testing.T) {
+	saved := retryBase
+	retryBase = 10 * time.Millisecond
+	t.Cleanup(func() { retryBase = saved })
+}
+
+func TestRetryKeepsOrder(t *testing.T) {
+	shortRetries(t)
+	rc := &receiver{t: t, secret: "s3cret", failures: 3}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	d, err := Open(filepath.Join(t.TempDir(), "outbox.db"), []Target{{URL: srv.URL, Secret: rc.secret}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	for i := 1; i <= 3; i++ {
+		if err := d.Publish(EventTransactionCompleted, map[string]int{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Start()
+
+	events := rc.wait(3)
+	for i, e := range events {
+		if n := e.Data.(map[string]any)["n"]; n != float64(i+1) {
+			t.Fatalf("delivery %d carried event %v, want %d", i, n, i+1)
+		}
+	}
+
+	// The first event was retried; the others waited behind it
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	want := []string{events[0].ID, events[0].ID, events[0].ID, events[0].ID, events[1].ID, events[2].ID}
+	if len(rc.attempts) != len(want) {
+		t.Fatalf("attempts %v, want %v", rc.attempts, want)
+	}
+	for i := range want {
+		if rc.attempts[i] != want[i] {
+			t.Fatalf("attempts %v, want %v", rc.attempts, want)
+		}
+	}
+}
+
+func TestOutboxSurvivesReopen(t *testing.T) {
+	shortRetries(t)
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	rc := &receiver{t: t, secret: "s3cret", failures: 1}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	targets := []Target{{URL: srv.URL, Secret: rc.secret}}
+
+	// Queue two events and fail the first attempt
+	d, err := Open(path, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Publish(EventTerminalConnected, TerminalData{Connected: true})
+	d.Publish(EventTerminalDisconnected, TerminalData{Connected: false})
+	retryBase = time.Hour
+	d.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for queuedDeliveries(d)[0].Attempts == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no delivery attempt recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.Stop()
+
+	// The retry state is on disk
+	d, err = Open(path, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := queuedDeliveries(d)
+	if len(pending) != 2 || pending[0].Attempts != 1 || pending[0].LastError == "" {
+		t.Fatalf("pending after reopen = %+v, want 2 with the first attempt recorded", pending)
+	}
+
+	// Both go out, in order, once the retry is due
+	defer d.Stop()
+	d.db.Update(func(tx *bolt.Tx) error { // Due now rather than in an hour
+		b := tx.Bucket(bucketPending)
+		return b.ForEach(func(k, v []byte) error {
+			var del Delivery
+			json.Unmarshal(v, &del)
+			del.NextAttempt = time.Time{}
+			data, _ := json.Marshal(del)
+			return b.Put(k, data)
+		})
+	})
+	d.Start()
+	events := rc.wait(2)
+	if events[0].Type != EventTerminalConnected || events[1].Type != EventTerminalDisconnected {
+		t.Fatalf("delivered %s then %s, want connected then disconnected", events[0].Type, events[1].Type)
+	}
+	if events[0].ID != pending[0].EventID || events[1].ID != pending[1].EventID {
+		t.Fatalf("delivered IDs %s %s, want the queued %s %s", events[0].ID, events[1].ID, pending[0].EventID, pending[1].EventID)
+	}
+}
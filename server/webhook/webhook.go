@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Event types
+const (
+	EventTransactionCompleted = "transaction.completed" // Card transaction finished (approved, declined, failed or unknown)
+	EventSettlementCompleted  = "settlement.completed"  // Settlement finished
+	EventTerminalConnected    = "terminal.connected"
+	EventTerminalDisconnected = "terminal.disconnected"
+)
+
+// EventTypes lists every event a target can subscribe to
+var EventTypes = []string{
+	EventTransactionCompleted,
+	EventSettlementCompleted,
+	EventTerminalConnected,
+	EventTerminalDisconnected,
+}
+
+// Delivery headers
+const (
+	HeaderEvent     = "X-ECPay-Event"
+	HeaderDelivery  = "X-ECPay-Delivery"
+	HeaderTimestamp = "X-ECPay-Timestamp"
+	HeaderSignature = "X-ECPay-Signature"
+)
+
+// Target is a webhook endpoint from the "webhooks" section of the config file
+type Target struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`           // HMAC-SHA256 signing key
+	Events []string `json:"events,omitempty"` // Empty: every event
+}
+
+// wants reports whether the target subscribes to eventType
+func (t Target) wants(eventType string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, e := range t.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks a list of targets without opening an outbox
+func Validate(targets []Target) error {
+	for _, t := range targets {
+		if err := t.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t Target) validate() error {
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook url %q must be an http(s) URL", t.URL)
+	}
+	if t.Secret == "" {
+		return fmt.Errorf("webhook %s needs a secret", t.URL)
+	}
+	for _, e := range t.Events {
+		known := false
+		for _, name := range EventTypes {
+			known = known || e == name
+		}
+		if !known {
+			return fmt.Errorf("webhook %s: unknown event %q", t.URL, e)
+		}
+	}
+	return nil
+}
+
+// Event is the JSON body posted to webhook targets
+type Event struct {
+	ID        string      `json:"id"` // Same for every retry; receivers use it to deduplicate
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// TerminalData is the payload of terminal.connected and terminal.disconnected
+type TerminalData struct {
+	Connected bool `json:"connected"`
+}
+
+// Sign computes the X-ECPay-Signature value for a delivery: the hex
+// HMAC-SHA256 of "<timestamp>.<body>" with the target's secret
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newEventID returns a unique ID that sorts by creation time
+func newEventID(now time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("evt-%019d-%s", now.UnixNano(), hex.EncodeToString(b))
+}
@@ -48,7 +48,8 @@ echo "      ✓ Mock POS started"
 # 2. Start Server (auto-detects Mock POS via ECHO handshake)
 echo "[2/3] Starting Server (auto-detect mode)..."
 cd "$SCRIPT_DIR/server"
-./run_dev.sh > "$LOG_DIR/server.log" 2>&1 &
+# Development only: no credentials configured
+./run_dev.sh -insecure-no-auth > "$LOG_DIR/server.log" 2>&1 &
 SERVER_PID=$!
 echo $SERVER_PID > "$LOG_DIR/server.pid"
 echo "      Server Runner PID: $SERVER_PID"
@@ -4,7 +4,7 @@ import {
   type TransactionResult,
   type ServerStateString,
 } from "./hooks/useAppState";
-import { usePOS, type POSCallbacks } from "./hooks/usePOS";
+import { usePOS, amountToCents, type POSCallbacks } from "./hooks/usePOS";
 import { useOrders } from "./hooks/useOrders";
 import type { Order } from "./hooks/useOrders";
 import { Keypad } from "./components/Keypad";
@@ -38,6 +38,8 @@ function App() {
     updateServerState,
     transactionSuccess,
     transactionError,
+    transactionUnknown,
+    transactionReconciled,
     dismiss,
     setTab,
     setAmount,
@@ -71,6 +73,8 @@ function App() {
       onTransactionError: (error: string, result?: TransactionResult) => {
         transactionError(error, result);
       },
+      onTransactionUnknown: transactionUnknown,
+      onTransactionReconciled: transactionReconciled,
     }),
     [
       connect,
@@ -78,6 +82,8 @@ function App() {
       updateServerState,
       transactionSuccess,
       transactionError,
+      transactionUnknown,
+      transactionReconciled,
     ]
   );
 
@@ -98,7 +104,7 @@ function App() {
         type: (state.lastResult.TransType === "01" ? "SALE" : "REFUND") as
           | "SALE"
           | "REFUND",
-        amount: parseInt(state.lastResult.Amount || "0"),
+        amount: amountToCents(state.lastResult.Amount),
         orderNo: state.lastResult.OrderNo || "",
         approvalNo: state.lastResult.ApprovalNo || "",
         cardNo: state.lastResult.CardNo || "",
@@ -449,6 +455,34 @@ function App() {
               </>
             )}
 
+            {state.appState === "UNKNOWN" && (
+              <>
+                <div className="w-16 h-16 rounded-full bg-yellow-500/20 flex items-center justify-center text-yellow-500 mb-6">
+                  <AlertTriangle className="w-8 h-8" />
+                </div>
+                <h3 className="text-xl font-bold mb-2">Outcome Unknown</h3>
+                <p className="text-yellow-400 mb-2">
+                  The card may have been charged.
+                </p>
+                <p className="text-zinc-400 text-sm mb-2">
+                  Check the terminal before charging again. This updates if the
+                  terminal answers late or a supervisor resolves it.
+                </p>
+                <p className="text-zinc-500 text-xs mb-4">{state.message}</p>
+                {state.unknownTransactionId && (
+                  <p className="text-zinc-500 text-xs font-mono mb-6">
+                    {state.unknownTransactionId}
+                  </p>
+                )}
+                <button
+                  onClick={handleDismiss}
+                  className="w-full py-3 bg-zinc-800 hover:bg-zinc-700 rounded-xl font-medium transition-colors"
+                >
+                  Dismiss
+                </button>
+              </>
+            )}
+
             {(state.appState === "ERROR" || state.appState === "TIMEOUT") && (
               <>
                 <div className="w-16 h-16 rounded-full bg-red-500/20 flex items-center justify-center text-red-500 mb-6">
@@ -11,6 +11,8 @@
  *   SUCCESS: Transaction approved (server: SUCCESS)
  *   ERROR: Transaction failed (server: ERROR)
  *   TIMEOUT: Transaction timed out (server: TIMEOUT)
+ *   UNKNOWN: Sent but never answered; the card may have been charged until
+ *            the server reconciles it
  */
 
 import { useReducer, useCallback } from "react";
@@ -22,7 +24,8 @@ export type AppState =
   | "PROCESSING" // Transaction in progress (server: SENDING/WAIT_ACK/WAIT_RESPONSE/PARSING)
   | "SUCCESS" // Transaction approved (server: SUCCESS)
   | "ERROR" // Transaction failed (server: ERROR)
-  | "TIMEOUT"; // Transaction timed out (server: TIMEOUT)
+  | "TIMEOUT" // Transaction timed out (server: TIMEOUT)
+  | "UNKNOWN"; // Outcome unknown, possibly charged (server: "unknown" result)
 
 // Server state strings (exactly as defined in state.go)
 export type ServerStateString =
@@ -95,6 +98,9 @@ export interface AppStateData {
   // Transaction result
   lastResult: TransactionResult | null;
 
+  // Transaction whose outcome is unknown, until the server reconciles it
+  unknownTransactionId: string | null;
+
   // Form state
   form: FormState;
 }
@@ -115,6 +121,14 @@ export type AppEvent =
   | { type: "TRANSACTION_SUCCESS"; result: TransactionResult }
   | { type: "TRANSACTION_ERROR"; error: string; result?: TransactionResult }
   | { type: "TRANSACTION_TIMEOUT" }
+  | { type: "TRANSACTION_UNKNOWN"; message: string; transactionId?: string }
+  | {
+      type: "TRANSACTION_RECONCILED";
+      transactionId: string;
+      approved: boolean;
+      message: string;
+      result: TransactionResult;
+    }
   | { type: "DISMISS" } // Dismiss success/error/timeout modal
   | { type: "RESET_FORM" }
   | { type: "SET_TAB"; tab: "SALE" | "REFUND" }
@@ -138,6 +152,7 @@ const initialState: AppStateData = {
   elapsed_ms: 0,
   timeout_ms: null,
   lastResult: null,
+  unknownTransactionId: null,
   form: {
     tab: "SALE",
     amount: "",
@@ -167,7 +182,11 @@ function reducer(state: AppStateData, event: AppEvent): AppStateData {
       };
 
     case "SERVER_STATE_UPDATE": {
-      const newAppState = serverStateToAppState(event.state);
+      // A possibly charged transaction stays on screen until dismissed
+      const newAppState =
+        state.appState === "UNKNOWN" && event.state === "IDLE"
+          ? "UNKNOWN"
+          : serverStateToAppState(event.state);
       return {
         ...state,
         serverState: event.state,
@@ -214,6 +233,34 @@ function reducer(state: AppStateData, event: AppEvent): AppStateData {
         lastError: "operation timed out",
       };
 
+    case "TRANSACTION_UNKNOWN":
+      return {
+        ...state,
+        appState: "UNKNOWN",
+        message: event.message,
+        lastError: event.message,
+        lastResult: null,
+        unknownTransactionId: event.transactionId ?? null,
+      };
+
+    case "TRANSACTION_RECONCILED":
+      // Only the transaction this till was told is unknown, and not over
+      // a transaction in progress
+      if (
+        event.transactionId !== state.unknownTransactionId ||
+        !["UNKNOWN", "IDLE"].includes(state.appState)
+      ) {
+        return state;
+      }
+      return {
+        ...state,
+        appState: event.approved ? "SUCCESS" : "ERROR",
+        message: event.message,
+        lastError: event.approved ? null : event.message,
+        lastResult: event.result,
+        unknownTransactionId: null,
+      };
+
     case "DISMISS":
       return {
         ...state,
@@ -301,7 +348,9 @@ export function canInputForm(state: AppStateData): boolean {
 }
 
 export function showModal(state: AppStateData): boolean {
-  return ["PROCESSING", "SUCCESS", "ERROR", "TIMEOUT"].includes(state.appState);
+  return ["PROCESSING", "SUCCESS", "ERROR", "TIMEOUT", "UNKNOWN"].includes(
+    state.appState
+  );
 }
 
 // Hook
@@ -353,6 +402,31 @@ export function useAppState() {
     []
   );
 
+  const transactionUnknown = useCallback(
+    (message: string, transactionId?: string) => {
+      dispatch({ type: "TRANSACTION_UNKNOWN", message, transactionId });
+    },
+    []
+  );
+
+  const transactionReconciled = useCallback(
+    (
+      transactionId: string,
+      approved: boolean,
+      message: string,
+      result: TransactionResult
+    ) => {
+      dispatch({
+        type: "TRANSACTION_RECONCILED",
+        transactionId,
+        approved,
+        message,
+        result,
+      });
+    },
+    []
+  );
+
   const dismiss = useCallback(() => dispatch({ type: "DISMISS" }), []);
   const resetForm = useCallback(() => dispatch({ type: "RESET_FORM" }), []);
 
@@ -392,6 +466,8 @@ export function useAppState() {
     transactionSuccess,
     transactionError,
     transactionTimeout,
+    transactionUnknown,
+    transactionReconciled,
     dismiss,
     resetForm,
     setTab,
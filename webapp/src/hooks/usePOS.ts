@@ -8,10 +8,36 @@
 import { useEffect, useRef, useCallback, useState } from "react";
 import type { ServerStateString, TransactionResult } from "./useAppState";
 
+// Newest server protocol version this client understands
+const PROTOCOL_VERSION = 1;
+
+// The keypad works in cents; the server takes and returns decimal amounts
+// ("1.00" is NT$1.00).
+export function centsToAmount(cents: string): string {
+  return (parseInt(cents || "0") / 100).toFixed(2);
+}
+
+export function amountToCents(amount?: string): number {
+  return Math.round(parseFloat((amount || "0").replace(/,/g, "")) * 100) || 0;
+}
+
 export interface POSResponse {
-  status: "processing" | "success" | "error" | "status_update";
+  status:
+    | "processing"
+    | "success"
+    | "error"
+    | "unknown"
+    | "reconciled"
+    | "status_update"
+    | "hello";
   message: string;
-  command_type?: "transaction" | "control" | "status";
+  command_type?:
+    | "transaction"
+    | "control"
+    | "status"
+    | "reconciliation"
+    | "hello";
+  transaction_id?: string;
   data?: {
     TransType?: string;
     Amount?: string;
@@ -28,6 +54,31 @@ export interface POSResponse {
   };
 }
 
+// Data of a "reconciled" message: the real outcome of a transaction that was
+// reported as unknown, from a late terminal response or an operator
+interface ReconciledTransaction {
+  transaction_id: string;
+  trans_type?: string;
+  amount?: string;
+  order_no?: string;
+  outcome: "APPROVED" | "DECLINED";
+  result?: Record<string, string>;
+}
+
+// reconciledResult takes the terminal's late response, or what the server
+// knows of the request when an operator resolved it
+function reconciledResult(tx: ReconciledTransaction): TransactionResult {
+  const r: Record<string, string> = tx.result ?? {};
+  return {
+    TransType: r.TransType ?? (tx.trans_type === "SALE" ? "01" : "02"),
+    Amount: r.Amount ?? tx.amount,
+    ApprovalNo: r.ApprovalNo,
+    OrderNo: r.OrderNo ?? tx.order_no,
+    CardNo: r.CardNo,
+    RespCode: r.RespCode,
+  };
+}
+
 export interface POSCallbacks {
   onConnect: () => void;
   onDisconnect: () => void;
@@ -40,6 +91,13 @@ export interface POSCallbacks {
   ) => void;
   onTransactionSuccess: (result: TransactionResult) => void;
   onTransactionError: (error: string, result?: TransactionResult) => void;
+  onTransactionUnknown: (message: string, transactionId?: string) => void;
+  onTransactionReconciled: (
+    transactionId: string,
+    approved: boolean,
+    message: string,
+    result: TransactionResult
+  ) => void;
 }
 
 export function usePOS(callbacks: POSCallbacks) {
@@ -58,7 +116,13 @@ export function usePOS(callbacks: POSCallbacks) {
     let reconnectTimer: ReturnType<typeof setTimeout>;
 
     const connect = () => {
-      socket = new WebSocket("ws://localhost:8989/ws");
+      // Pre-shared token, required when the server has auth tokens configured
+      const token = import.meta.env.VITE_ECPAY_TOKEN;
+      // Pages served over HTTPS may only open wss:// (server started with -tls)
+      const scheme = window.location.protocol === "https:" ? "wss" : "ws";
+      const base = `${scheme}://localhost:8989/ws?protocol_version=${PROTOCOL_VERSION}`;
+      const url = token ? `${base}&token=${encodeURIComponent(token)}` : base;
+      socket = new WebSocket(url);
 
       socket.onopen = () => {
         console.log("Connected to POS Server");
@@ -140,9 +204,35 @@ export function usePOS(callbacks: POSCallbacks) {
               }
               break;
 
+            case "unknown":
+              // Sent but never answered: the card may have been charged
+              if (resp.command_type === "transaction") {
+                callbacks.onTransactionUnknown(resp.message, resp.transaction_id);
+              }
+              break;
+
+            case "reconciled": {
+              // The real outcome of an unknown transaction, possibly minutes later
+              if (resp.data) {
+                const tx = resp.data as unknown as ReconciledTransaction;
+                callbacks.onTransactionReconciled(
+                  tx.transaction_id,
+                  tx.outcome === "APPROVED",
+                  resp.message,
+                  reconciledResult(tx)
+                );
+              }
+              break;
+            }
+
             case "processing":
               // Processing notifications are informational, state update will follow
               break;
+
+            case "hello":
+              // Server introduction: negotiated protocol and server version
+              console.log("Server hello:", resp.data);
+              break;
           }
         } catch (e) {
           console.error("Parse error:", e);
@@ -172,11 +262,11 @@ export function usePOS(callbacks: POSCallbacks) {
         return false;
       }
 
-      addLog(`Sending ${command}: $${(parseInt(amount) / 100).toFixed(2)}`);
+      addLog(`Sending ${command}: $${centsToAmount(amount)}`);
       ws.current.send(
         JSON.stringify({
           command,
-          amount,
+          amount: centsToAmount(amount),
           order_no: orderNo,
         })
       );